@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/core"
+	"github.com/lorenzodonini/ocpp-go/ws"
+)
+
+// ChargePointCredential holds the Basic Auth secret of a charge point (OCPP security profile 1).
+// Only a salted hash of the password is kept, the plain password is handed out once on provisioning/rotation.
+type ChargePointCredential struct {
+	Salt         string    `json:"salt"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+	RotatedAt    time.Time `json:"rotated_at"`
+}
+
+func hashChargePointPassword(salt string, password string) string {
+	sum := sha256.Sum256([]byte(salt + password))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(bytes int) (string, error) {
+	buf := make([]byte, bytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func newChargePointCredential(password string) (*ChargePointCredential, error) {
+	salt, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &ChargePointCredential{Salt: salt, PasswordHash: hashChargePointPassword(salt, password), CreatedAt: now, RotatedAt: now}, nil
+}
+
+func (cred *ChargePointCredential) matches(password string) bool {
+	hash := hashChargePointPassword(cred.Salt, password)
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(cred.PasswordHash)) == 1
+}
+
+// setupWebsocketServer creates the websocket server for the central system with Basic Auth enabled.
+// The username must be the charge point ID, which must also match the last element of the connection path.
+func setupWebsocketServer(handler *CentralSystemHandler) *ws.Server {
+	server := ws.NewServer()
+	server.SetBasicAuthHandler(handler.checkChargePointAuth)
+	server.SetCheckOriginHandler(checkChargePointOrigin)
+	return server
+}
+
+// checkChargePointOrigin is the websocket origin check, it matches the basic auth user with the charge point ID
+// as well since the basic auth handler doesn't get to see the connection path
+func checkChargePointOrigin(r *http.Request) bool {
+	if username, _, ok := r.BasicAuth(); ok && username != path.Base(r.URL.Path) {
+		log.WithField("client", path.Base(r.URL.Path)).Warnf("rejected connection, basic auth user %v doesn't match charge point id", username)
+		return false
+	}
+	// same origin policy of the websocket upgrader, chargers don't send an Origin header
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	originURL, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(originURL.Host, r.Host)
+}
+
+// checkChargePointAuth checks the password of provisioned charge points, the others are only let in as long as
+// authentication isn't required
+func (handler *CentralSystemHandler) checkChargePointAuth(username string, password string) bool {
+	cred, exists := handler.Credentials[username]
+	if !exists {
+		if !handler.ChargePointAuthRequired {
+			return true
+		}
+		log.WithField("client", username).Warn("rejected connection from unknown charge point")
+		return false
+	}
+	if !cred.matches(password) {
+		log.WithField("client", username).Warn("rejected connection, wrong password")
+		return false
+	}
+	return true
+}
+
+// SetChargePointAuthRequired Http-RPC, requiring authentication is refused while known charge points aren't
+// provisioned, they would be locked out
+func (handler *CentralSystemHandler) SetChargePointAuthRequired(required bool) error {
+	if required {
+		var missing []string
+		for name := range handler.ChargePoints {
+			if _, exists := handler.Credentials[name]; !exists {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return fmt.Errorf("charge points without credentials: %v", strings.Join(missing, ", "))
+		}
+	}
+	handler.ChargePointAuthRequired = required
+	log.Printf("charge point authentication required: %v", required)
+	return nil
+}
+
+// ProvisionChargePoint Http-RPC, registers credentials for a charge point. A random password is generated if none is given.
+func (handler *CentralSystemHandler) ProvisionChargePoint(chargePointID string, password string) (string, error) {
+	if chargePointID == "" {
+		return "", fmt.Errorf("empty charge point id")
+	}
+	if _, exists := handler.Credentials[chargePointID]; exists {
+		return "", fmt.Errorf("charge point %v already provisioned, use rotateChargePointPassword", chargePointID)
+	}
+	var err error
+	if password == "" {
+		password, err = randomHex(chargepointpasswordbytes)
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(password) < 16 || len(password) > 40 {
+		return "", fmt.Errorf("password must be between 16 and 40 characters")
+	}
+	cred, err := newChargePointCredential(password)
+	if err != nil {
+		return "", err
+	}
+	handler.Credentials[chargePointID] = cred
+	log.WithField("client", chargePointID).Info("charge point credentials provisioned")
+	return password, nil
+}
+
+// RotateChargePointPassword Http-RPC, pushes a new AuthorizationKey to a connected charge point and stores it once accepted.
+func (handler *CentralSystemHandler) RotateChargePointPassword(chargePointID string) (string, error) {
+	cred, exists := handler.Credentials[chargePointID]
+	if !exists {
+		return "", fmt.Errorf("charge point %v not provisioned", chargePointID)
+	}
+	password, err := randomHex(chargepointpasswordbytes)
+	if err != nil {
+		return "", err
+	}
+	if !handler.SetConfig(chargePointID, "AuthorizationKey", password) {
+		return "", fmt.Errorf("charge point %v didn't accept the new %v", chargePointID, "AuthorizationKey")
+	}
+	salt, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	cred.Salt = salt
+	cred.PasswordHash = hashChargePointPassword(salt, password)
+	cred.RotatedAt = time.Now()
+	logDefault(chargePointID, core.ChangeConfigurationFeatureName).Info("charge point password rotated")
+	return password, nil
+}
+
+// RevokeChargePoint Http-RPC, removes the credentials, the charge point can't connect anymore while authentication is required.
+func (handler *CentralSystemHandler) RevokeChargePoint(chargePointID string) bool {
+	if _, exists := handler.Credentials[chargePointID]; !exists {
+		return false
+	}
+	delete(handler.Credentials, chargePointID)
+	log.WithField("client", chargePointID).Info("charge point credentials revoked")
+	return true
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/firmware"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+const (
+	CampaignStateRunning   = "Running"
+	CampaignStatePaused    = "Paused"
+	CampaignStateCompleted = "Completed"
+	CampaignStateCancelled = "Cancelled"
+
+	CampaignTargetScheduled = "Scheduled"
+	CampaignTargetRequested = "Requested"
+	CampaignTargetFailed    = "Failed"
+)
+
+// FirmwareCampaign rolls a firmware image out to chargers in batches, batch 0 being the canary batch
+type FirmwareCampaign struct {
+	Id           int               `json:"id"`
+	Image        string            `json:"image"`
+	Location     string            `json:"location"`
+	State        string            `json:"state"`
+	PauseReason  string            `json:"pause_reason"`
+	CurrentBatch int               `json:"current_batch"`
+	Batches      int               `json:"batches"`
+	Targets      []*CampaignTarget `json:"targets"`
+	Created      *types.DateTime   `json:"created"`
+}
+
+// CampaignTarget is the rollout state of one charger, Status holds the last firmware status reported
+type CampaignTarget struct {
+	ChargePointID string          `json:"charge_point_id"`
+	Batch         int             `json:"batch"`
+	Status        string          `json:"status"`
+	Error         string          `json:"error"`
+	Updated       *types.DateTime `json:"updated"`
+}
+
+func (target *CampaignTarget) setStatus(status string) {
+	target.Status = status
+	target.Updated = types.NewDateTime(time.Now())
+}
+
+func (cps *ChargePointState) hasActiveTransaction() bool {
+	for _, connector := range cps.Connectors {
+		if connector.hasTransactionInProgress() {
+			return true
+		}
+	}
+	return false
+}
+
+func (campaign *FirmwareCampaign) isActive() bool {
+	return campaign.State == CampaignStateRunning || campaign.State == CampaignStatePaused
+}
+
+// CreateFirmwareCampaign Http-RPC, the first canarySize chargers are updated alone, the rest in batches of batchSize
+func (handler *CentralSystemHandler) CreateFirmwareCampaign(image string, chargers []string, canarySize int, batchSize int) (*FirmwareCampaign, error) {
+	if !firmwareImageExists(image) {
+		return nil, fmt.Errorf("firmware image %v not uploaded", image)
+	}
+	if len(chargers) == 0 {
+		return nil, fmt.Errorf("no chargers given")
+	}
+	if canarySize < 1 || batchSize < 1 {
+		return nil, fmt.Errorf("canary and batch size must be at least 1")
+	}
+	url, err := handler.requireFileServerURL()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range chargers {
+		if _, err := handler.chargePointByID(name); err != nil {
+			return nil, err
+		}
+		for _, campaign := range handler.Campaigns {
+			if !campaign.isActive() {
+				continue
+			}
+			for _, target := range campaign.Targets {
+				if target.ChargePointID == name {
+					return nil, fmt.Errorf("%v is already part of campaign %v", name, campaign.Id)
+				}
+			}
+		}
+	}
+	campaign := &FirmwareCampaign{
+		Id:       handler.NextCampaignID,
+		Image:    image,
+		Location: url + "/files/firmware/" + image,
+		State:    CampaignStateRunning,
+		Created:  types.NewDateTime(time.Now()),
+	}
+	handler.NextCampaignID++
+	for i, name := range chargers {
+		batch := 0
+		if i >= canarySize {
+			batch = 1 + (i-canarySize)/batchSize
+		}
+		campaign.Targets = append(campaign.Targets, &CampaignTarget{ChargePointID: name, Batch: batch, Status: CampaignTargetScheduled, Updated: types.NewDateTime(time.Now())})
+		campaign.Batches = batch + 1
+	}
+	handler.Campaigns[campaign.Id] = campaign
+	log.Printf("firmware campaign %v created for %v chargers in %v batches", campaign.Id, len(chargers), campaign.Batches)
+	return campaign, nil
+}
+
+// SetFirmwareCampaignState Http-RPC, pauses, resumes or cancels a campaign
+func (handler *CentralSystemHandler) SetFirmwareCampaignState(id int, state string) (*FirmwareCampaign, error) {
+	campaign, ok := handler.Campaigns[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown campaign %v", id)
+	}
+	if !campaign.isActive() {
+		return nil, fmt.Errorf("campaign %v is %v", id, campaign.State)
+	}
+	switch state {
+	case CampaignStatePaused:
+		campaign.PauseReason = "paused by operator"
+	case CampaignStateRunning:
+		campaign.PauseReason = ""
+		// failed chargers of the current batch are retried after resuming
+		for _, target := range campaign.Targets {
+			if target.Batch == campaign.CurrentBatch && target.Status == CampaignTargetFailed {
+				target.setStatus(CampaignTargetScheduled)
+			}
+		}
+	case CampaignStateCancelled:
+	default:
+		return nil, fmt.Errorf("unknown state %v", state)
+	}
+	campaign.State = state
+	log.Printf("firmware campaign %v is now %v", id, state)
+	return campaign, nil
+}
+
+// GetFirmwareCampaigns Http-RPC
+func (handler *CentralSystemHandler) GetFirmwareCampaigns() map[int]*FirmwareCampaign {
+	return handler.Campaigns
+}
+
+func (handler *CentralSystemHandler) campaignstart() {
+	log.Println("Starting firmware campaign scheduler")
+	ticker := time.NewTicker(campaigninterval * time.Second)
+	go func() {
+		for range ticker.C {
+			for _, campaign := range handler.Campaigns {
+				if campaign.State == CampaignStateRunning {
+					handler.campaignStep(campaign)
+				}
+			}
+		}
+	}()
+}
+
+// campaignStep sends UpdateFirmware to idle chargers of the current batch and moves on once the batch is installed
+func (handler *CentralSystemHandler) campaignStep(campaign *FirmwareCampaign) {
+	installed := 0
+	inBatch := 0
+	for _, target := range campaign.Targets {
+		if target.Status == CampaignTargetFailed {
+			campaign.State = CampaignStatePaused
+			campaign.PauseReason = fmt.Sprintf("update of %v failed: %v", target.ChargePointID, target.Error)
+			log.Printf("firmware campaign %v paused, %v", campaign.Id, campaign.PauseReason)
+			return
+		}
+		if target.Batch != campaign.CurrentBatch {
+			continue
+		}
+		inBatch++
+		switch target.Status {
+		case string(firmware.FirmwareStatusInstalled):
+			installed++
+		case CampaignTargetScheduled:
+			handler.requestFirmwareUpdate(campaign, target)
+		default:
+			// a charger that stops reporting would hold up the batch forever
+			if target.Updated != nil && time.Since(target.Updated.Time) > campaigntargettimeout*time.Minute {
+				target.Error = fmt.Sprintf("no firmware status for %v minutes", campaigntargettimeout)
+				target.setStatus(CampaignTargetFailed)
+			}
+		}
+	}
+	if installed < inBatch {
+		return
+	}
+	campaign.CurrentBatch++
+	if campaign.CurrentBatch >= campaign.Batches {
+		campaign.State = CampaignStateCompleted
+		log.Printf("firmware campaign %v completed", campaign.Id)
+	} else {
+		log.Printf("firmware campaign %v continues with batch %v/%v", campaign.Id, campaign.CurrentBatch, campaign.Batches-1)
+	}
+}
+
+func (handler *CentralSystemHandler) requestFirmwareUpdate(campaign *FirmwareCampaign, target *CampaignTarget) {
+	cp, ok := handler.ChargePoints[target.ChargePointID]
+	if !ok || !handler.setupStarted[target.ChargePointID] || cp.hasActiveTransaction() {
+		// offline, not accepted yet or charging, try again next round
+		return
+	}
+	request := firmware.NewUpdateFirmwareRequest(campaign.Location, types.NewDateTime(time.Now()))
+	_, err := handler.sendRequestSync(target.ChargePointID, request)
+	if err != nil {
+		logDefault(target.ChargePointID, request.GetFeatureName()).Errorf("error on request: %v", err)
+		target.Error = err.Error()
+		target.setStatus(CampaignTargetFailed)
+		return
+	}
+	logDefault(target.ChargePointID, request.GetFeatureName()).Infof("firmware update to %v requested (campaign %v)", campaign.Image, campaign.Id)
+	target.setStatus(CampaignTargetRequested)
+}
+
+// updateCampaignTarget follows the firmware status of a charger in its active campaign
+func (handler *CentralSystemHandler) updateCampaignTarget(chargePointID string, status firmware.FirmwareStatus) {
+	for _, campaign := range handler.Campaigns {
+		if !campaign.isActive() {
+			continue
+		}
+		for _, target := range campaign.Targets {
+			if target.ChargePointID != chargePointID || target.Status == CampaignTargetScheduled {
+				continue
+			}
+			switch status {
+			case firmware.FirmwareStatusDownloadFailed, firmware.FirmwareStatusInstallationFailed:
+				target.Error = string(status)
+				target.setStatus(CampaignTargetFailed)
+			case firmware.FirmwareStatusIdle:
+				// Idle after a reboot doesn't tell us anything
+			default:
+				target.setStatus(string(status))
+			}
+		}
+	}
+}
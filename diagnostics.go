@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/firmware"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+const DiagnosticsStatusRequested = "Requested"
+
+// DiagnosticsRecord is one GetDiagnostics request and the archive the charger uploaded for it
+type DiagnosticsRecord struct {
+	Requested  *types.DateTime `json:"requested"`
+	StartTime  *types.DateTime `json:"start_time"`
+	StopTime   *types.DateTime `json:"stop_time"`
+	FileName   string          `json:"file_name"`
+	Status     string          `json:"status"`
+	StoredFile string          `json:"stored_file"`
+	Size       int64           `json:"size"`
+	SHA256     string          `json:"sha256"`
+	Uploaded   *types.DateTime `json:"uploaded"`
+}
+
+func (cps *ChargePointState) lastDiagnostics() *DiagnosticsRecord {
+	if len(cps.Diagnostics) == 0 {
+		return nil
+	}
+	return cps.Diagnostics[len(cps.Diagnostics)-1]
+}
+
+// RequestDiagnostics Http-RPC, asks the charger to upload its diagnostics for the time range to the built-in receiver
+func (handler *CentralSystemHandler) RequestDiagnostics(chargePointID string, startTime *types.DateTime, stopTime *types.DateTime) (*DiagnosticsRecord, error) {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return nil, err
+	}
+	url, err := handler.requireFileServerURL()
+	if err != nil {
+		return nil, err
+	}
+	request := firmware.NewGetDiagnosticsRequest(url + "/files/diagnostics/" + chargePointID + "/")
+	request.StartTime = startTime
+	request.EndTime = stopTime
+	response, err := handler.sendRequestSync(chargePointID, request)
+	if err != nil {
+		logDefault(chargePointID, request.GetFeatureName()).Errorf("error on request: %v", err)
+		return nil, err
+	}
+	confirmation := response.(*firmware.GetDiagnosticsConfirmation)
+	if confirmation.FileName == "" {
+		return nil, fmt.Errorf("charge point %v has no diagnostics available", chargePointID)
+	}
+	record := &DiagnosticsRecord{Requested: types.NewDateTime(time.Now()), StartTime: startTime, StopTime: stopTime, FileName: confirmation.FileName, Status: DiagnosticsStatusRequested}
+	cp.Diagnostics = append(cp.Diagnostics, record)
+	logDefault(chargePointID, request.GetFeatureName()).Infof("diagnostics %v requested", confirmation.FileName)
+	return record, nil
+}
+
+// GetDiagnosticsList Http-RPC
+func (handler *CentralSystemHandler) GetDiagnosticsList(chargePointID string) ([]*DiagnosticsRecord, error) {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return nil, err
+	}
+	return cp.Diagnostics, nil
+}
+
+// diagnosticsUpload receives the archive by HTTP PUT or POST (raw body or multipart form)
+func (handler *CentralSystemHandler) diagnosticsUpload(w http.ResponseWriter, r *http.Request) {
+	chargePointID := mux.Vars(r)["chargepoint"]
+	name := mux.Vars(r)["name"]
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil || cp.lastDiagnostics() == nil || cp.lastDiagnostics().Uploaded != nil {
+		log.Printf("unexpected diagnostics upload for %v from %v", chargePointID, r.RemoteAddr)
+		http.Error(w, "no diagnostics requested", http.StatusForbidden)
+		return
+	}
+	record := cp.lastDiagnostics()
+	r.Body = http.MaxBytesReader(w, r.Body, maxdiagnosticsbytes)
+	body := io.Reader(r.Body)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		// the first part carrying a file is the archive, whatever the form field is called
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				http.Error(w, "no file in upload", http.StatusBadRequest)
+				return
+			}
+			if part.FileName() != "" {
+				body = part
+				if name == "" {
+					name = part.FileName()
+				}
+				break
+			}
+		}
+	}
+	if name == "" {
+		name = record.FileName
+	}
+	stored := time.Now().Format("20060102-150405") + "-" + filepath.Base(name)
+	upload, err := storeFile(filepath.Join(diagnosticsdir, chargePointID), stored, body)
+	if err != nil {
+		log.Printf("diagnostics upload for %v failed: %v", chargePointID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	record.StoredFile = upload.Name
+	record.Size = upload.Size
+	record.SHA256 = upload.SHA256
+	record.Uploaded = types.NewDateTime(time.Now())
+	logDefault(chargePointID, firmware.GetDiagnosticsFeatureName).Infof("diagnostics stored as %v (%v bytes)", upload.Name, upload.Size)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_ = json.NewEncoder(w).Encode(upload)
+}
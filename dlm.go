@@ -1,10 +1,26 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
 	"strconv"
 	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/smartcharging"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
 )
 
+// connectorRef addresses one connector of a charger in the DLM
+type connectorRef struct {
+	ChargePoint string
+	Connector   int
+}
+
+func (ref connectorRef) String() string {
+	return ref.ChargePoint + "/" + strconv.Itoa(ref.Connector)
+}
+
 func MustParseDuration(s string) time.Duration {
 	value, err := time.ParseDuration(s)
 	if err != nil {
@@ -33,6 +49,121 @@ func (handler *CentralSystemHandler) isOKChangingCurrent(chargePointID string, w
 
 }
 
+// legacyDLMState is the DLM state persisted per charger before it moved onto the connectors
+type legacyDLMState struct {
+	CurrentAssigned             PortCurrents  `json:"current_assigned"`
+	CurrentTargeted             *PortCurrents `json:"current_targeted"`
+	EVforDLMCycles              int           `json:"evfor_dlm_cycles"`
+	OfflineForDLMCycles         int           `json:"offline_for_dlm_cycles"`
+	ReducedPowerOfferring       bool          `json:"reduced_power_offerring"`
+	MaxingPowerForDLMCycles     int           `json:"maxing_power_for_dlm_cycles"`
+	NotUsingMaxForDLMCycles     int           `json:"not_using_max_for_dlm_cycles"`
+	UsingLessThan6AForDLMCycles int           `json:"using_less_than_6a_for_dlm_cycles"`
+}
+
+// migrateLegacyDLMState moves the DLM state of a persistence file written before the per connector DLM onto the
+// first connector, which was the only one the DLM handled. Otherwise an initialized charger would be set to 0 A
+// on its next connect until the DLM ramps it up again.
+func (handler *CentralSystemHandler) migrateLegacyDLMState(persistence []byte) {
+	var legacy struct {
+		ChargePoints map[string]*legacyDLMState `json:"charge_points"`
+	}
+	if err := json.Unmarshal(persistence, &legacy); err != nil {
+		return
+	}
+	for name, state := range legacy.ChargePoints {
+		cp, ok := handler.ChargePoints[name]
+		if !ok || state == nil || state.CurrentTargeted == nil {
+			continue
+		}
+		if cp.Connectors == nil {
+			cp.Connectors = map[int]*ConnectorInfo{}
+		}
+		connector := cp.getConnector(cp.dlmConnectorIDs()[0])
+		if connector.CurrentTargeted != (PortCurrents{}) || connector.CurrentAssigned != (PortCurrents{}) {
+			// already written by this version
+			continue
+		}
+		connector.CurrentAssigned = state.CurrentAssigned
+		connector.CurrentTargeted = *state.CurrentTargeted
+		connector.EVforDLMCycles = state.EVforDLMCycles
+		connector.OfflineForDLMCycles = state.OfflineForDLMCycles
+		connector.ReducedPowerOfferring = state.ReducedPowerOfferring
+		connector.MaxingPowerForDLMCycles = state.MaxingPowerForDLMCycles
+		connector.NotUsingMaxForDLMCycles = state.NotUsingMaxForDLMCycles
+		connector.UsingLessThan6AForDLMCycles = state.UsingLessThan6AForDLMCycles
+		if connector.CurrentOffered == 0 {
+			connector.Currents, connector.CurrentOffered = cp.Currents, cp.CurrentOffered
+		}
+		log.WithField("client", name).Infof("DLM state moved onto connector with %v A targeted", connector.CurrentTargeted)
+	}
+}
+
+// dlmConnectorIDs are the connectors of a charger in ascending order, a charger that didn't report any
+// connector yet is taken as single connector charger
+func (cps *ChargePointState) dlmConnectorIDs() []int {
+	ids := []int{}
+	for id := range cps.Connectors {
+		if id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return []int{1}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// capCurrent limits a current to the connector's maximum and its quota cap
+func (ci *ConnectorInfo) capCurrent(current int) int {
+	if ci.MaxCurrent > 0 && current > ci.MaxCurrent {
+		current = ci.MaxCurrent
+	}
+	if ci.QuotaCap > 0 && current > ci.QuotaCap {
+		current = ci.QuotaCap
+	}
+	return current
+}
+
+// setTargeted sets the current the DLM wants on all three phases, capped by the connector's maximum and quota cap
+func (ci *ConnectorInfo) setTargeted(current int) {
+	current = ci.capCurrent(current)
+	ci.CurrentTargeted.L1 = current
+	ci.CurrentTargeted.L2 = current
+	ci.CurrentTargeted.L3 = current
+}
+
+// applyConnectorLimit sends the current limit of a connector. Single connector chargers get the Juice ME
+// DLM operator limits, chargers with more connectors a TxDefaultProfile per connector.
+func (handler *CentralSystemHandler) applyConnectorLimit(chargePointID string, connectorID int, limit PortCurrents) bool {
+	if len(handler.ChargePoints[chargePointID].dlmConnectorIDs()) == 1 {
+		success1 := handler.SetConfig(chargePointID, "DlmOperatorPhase1Limit", strconv.Itoa(limit.L1))
+		success2 := handler.SetConfig(chargePointID, "DlmOperatorPhase2Limit", strconv.Itoa(limit.L2))
+		success3 := handler.SetConfig(chargePointID, "DlmOperatorPhase3Limit", strconv.Itoa(limit.L3))
+		return success1 && success2 && success3
+	}
+	current := limit.L1
+	if limit.L2 < current {
+		current = limit.L2
+	}
+	if limit.L3 < current {
+		current = limit.L3
+	}
+	schedule := types.NewChargingSchedule(types.ChargingRateUnitAmperes, types.NewChargingSchedulePeriod(0, float64(current)))
+	profile := types.NewChargingProfile(dlmchargingprofileid+connectorID, 0, types.ChargingProfilePurposeTxDefaultProfile, types.ChargingProfileKindAbsolute, schedule)
+	response, err := handler.sendRequestSync(chargePointID, smartcharging.NewSetChargingProfileRequest(connectorID, profile))
+	if err != nil {
+		logDefault(chargePointID, smartcharging.SetChargingProfileFeatureName).Errorf("couldn't set limit of connector %v: %v", connectorID, err)
+		return false
+	}
+	if status := response.(*smartcharging.SetChargingProfileConfirmation).Status; status != smartcharging.ChargingProfileStatusAccepted {
+		logDefault(chargePointID, smartcharging.SetChargingProfileFeatureName).Warnf("limit of connector %v %v", connectorID, status)
+		return false
+	}
+	return true
+}
+
 func (handler *CentralSystemHandler) dlm() {
 	currentoffered := make(map[string]int)
 	for name, _ := range handler.Groups {
@@ -41,38 +172,37 @@ func (handler *CentralSystemHandler) dlm() {
 	// Setting targeted Currents and ramping down only!!!
 	for name, cp := range handler.ChargePoints {
 		groupid := cp.DLMGroup
-		if cp.CurrentAssigned != cp.CurrentTargeted {
-			success1 := handler.SetConfig(name, "DlmOperatorPhase1Limit", strconv.Itoa(handler.ChargePoints[name].CurrentTargeted.L1))
-			success2 := handler.SetConfig(name, "DlmOperatorPhase2Limit", strconv.Itoa(handler.ChargePoints[name].CurrentTargeted.L2))
-			success3 := handler.SetConfig(name, "DlmOperatorPhase3Limit", strconv.Itoa(handler.ChargePoints[name].CurrentTargeted.L3))
-			if !success1 || !success2 || !success3 {
-				log.Println("Error whilst setting current from DLM")
-			} else {
-				cp.CurrentAssigned = cp.CurrentTargeted
-				handler.Groups[groupid].DLMActionPending = false
+		for _, id := range cp.dlmConnectorIDs() {
+			connector := cp.getConnector(id)
+			ref := connectorRef{name, id}
+			if connector.CurrentAssigned != connector.CurrentTargeted {
+				if !handler.applyConnectorLimit(name, id, connector.CurrentTargeted) {
+					log.Println("Error whilst setting current from DLM")
+				} else {
+					connector.CurrentAssigned = connector.CurrentTargeted
+					handler.Groups[groupid].DLMActionPending = false
+				}
 			}
-		}
-		if len(cp.Connectors) == 1 {
-			if cp.Connectors[1].Status == "Charging" && !cp.Connectors[1].DoneCharging {
-				currentoffered[groupid] += cp.CurrentOffered
+			if connector.Status == "Charging" && !connector.DoneCharging {
+				currentoffered[groupid] += connector.CurrentOffered
 			}
-			if cp.Connectors[1].Status == "Available" && cp.CurrentAssigned.L1 != 0 && !handler.Groups[groupid].DLMActionPending {
-				cp.CurrentTargeted.L1 = 0
-				cp.CurrentTargeted.L2 = 0
-				cp.CurrentTargeted.L3 = 0
+			if connector.Status == "Available" && connector.CurrentAssigned.L1 != 0 && !handler.Groups[groupid].DLMActionPending {
+				connector.CurrentTargeted.L1 = 0
+				connector.CurrentTargeted.L2 = 0
+				connector.CurrentTargeted.L3 = 0
 				handler.Groups[groupid].DLMActionPending = true
-				log.Printf("Chargepoint %v done charging/unplugged, reducing current to 0", name)
+				log.Printf("Chargepoint %v done charging/unplugged, reducing current to 0", ref)
 			}
-			if cp.Connectors[1].Status == "Unavailable" && cp.CurrentAssigned.L1 != 0 && cp.OfflineForDLMCycles > 70 {
-				cp.CurrentTargeted.L1 = 0
-				cp.CurrentTargeted.L2 = 0
-				cp.CurrentTargeted.L3 = 0
+			if connector.Status == "Unavailable" && connector.CurrentAssigned.L1 != 0 && connector.OfflineForDLMCycles > 70 {
+				connector.CurrentTargeted.L1 = 0
+				connector.CurrentTargeted.L2 = 0
+				connector.CurrentTargeted.L3 = 0
 				handler.Groups[groupid].DLMActionPending = true
-				log.Printf("Chargepoint %v unavailable/offline, reducing current to 0", name)
-			} else if cp.Connectors[1].Status == "Unavailable" {
-				cp.OfflineForDLMCycles++
+				log.Printf("Chargepoint %v unavailable/offline, reducing current to 0", ref)
+			} else if connector.Status == "Unavailable" {
+				connector.OfflineForDLMCycles++
 			} else {
-				cp.OfflineForDLMCycles = 0
+				connector.OfflineForDLMCycles = 0
 			}
 		}
 	}
@@ -105,14 +235,16 @@ func (handler *CentralSystemHandler) AssignedPowerGroupSumUp() {
 	}
 	for _, cp := range handler.ChargePoints {
 		groupid := cp.DLMGroup
-		//assignedcurrents
-		assigned[groupid]["L1AA"] += cp.CurrentAssigned.L1
-		assigned[groupid]["L2AA"] += cp.CurrentAssigned.L2
-		assigned[groupid]["L3AA"] += cp.CurrentAssigned.L3
-		//currentpower
-		assigned[groupid]["L1AC"] += cp.Currents.L1
-		assigned[groupid]["L2AC"] += cp.Currents.L2
-		assigned[groupid]["L3AC"] += cp.Currents.L3
+		for _, connector := range cp.Connectors {
+			//assignedcurrents
+			assigned[groupid]["L1AA"] += connector.CurrentAssigned.L1
+			assigned[groupid]["L2AA"] += connector.CurrentAssigned.L2
+			assigned[groupid]["L3AA"] += connector.CurrentAssigned.L3
+			//currentpower
+			assigned[groupid]["L1AC"] += connector.Currents.L1
+			assigned[groupid]["L2AC"] += connector.Currents.L2
+			assigned[groupid]["L3AC"] += connector.Currents.L3
+		}
 	}
 	for name, valuesmap := range assigned {
 		//assignedcurrent
@@ -133,134 +265,24 @@ func (handler *CentralSystemHandler) AssignedPowerGroupSumUp() {
 }
 
 func (handler *CentralSystemHandler) RampUpPower() {
-	wantsfullpower := make(map[string]map[string]bool)
+	wantsfullpower := make(map[string]map[connectorRef]bool)
 	newpower := make(map[string]map[string]int)
 	for name, _ := range handler.Groups {
 		newpower[name] = map[string]int{}
 		newpower[name]["group"] = 0
-		wantsfullpower[name] = map[string]bool{}
+		wantsfullpower[name] = map[connectorRef]bool{}
 	}
 	currentleftover := make(map[string]int)
 	for name, grp := range handler.Groups {
 		currentleftover[name] = grp.MaxL1 - grp.AssignedL1
 	}
 	for name, cp := range handler.ChargePoints {
-		if cp.Status == "Available" && len(cp.Connectors) == 1 { //Not shut down and Juice ME charger
-			groupid := cp.DLMGroup
-			if !cp.Connectors[1].DoneCharging {
-				//Method for giving standby Power
-				if cp.Connectors[1].OnlyStandby && !cp.Connectors[1].DoneCharging {
-					cp.CurrentTargeted.L1 = 6
-					cp.CurrentTargeted.L2 = 6
-					cp.CurrentTargeted.L3 = 6
-					grouppower := newpower[groupid]
-					cp.ReducedPowerOfferring = true
-					grouppower["group"] += 6
-					handler.Groups[groupid].DLMActionPending = true
-				}
-
-				//Method for detecting Repower after standby has been detected
-				if cp.Connectors[1].OnlyStandby && cp.MaxingPowerForDLMCycles > dlmrampupfromstandby {
-					cp.Connectors[1].OnlyStandby = false
-					handler.ResetDLM(name)
-					log.Printf("%v wants more power, pulling them out of stanby 6A mode after they maxed that for %v times", name, dlmrampupfromstandby)
-					//Car wants moar powaaarrr, assume that charging limit was increased, thus we assume it as normal charging at full rate
-					//wantsfullpower[groupid][name] = true
-					cp.CurrentTargeted.L1 = 8
-					cp.CurrentTargeted.L2 = 8
-					cp.CurrentTargeted.L3 = 8
-					grouppower := newpower[groupid]
-					cp.ReducedPowerOfferring = true
-					grouppower["group"] += 8
-				} else if cp.Connectors[1].OnlyStandby && (cp.Currents.L1 > dlmrampupfromstandbyaftercurrent || cp.Currents.L2 > dlmrampupfromstandbyaftercurrent || cp.Currents.L3 > dlmrampupfromstandbyaftercurrent) {
-					cp.MaxingPowerForDLMCycles = cp.MaxingPowerForDLMCycles + 1
-					log.Printf("%v maxing standby 6A, waiting for total %v/%v cycles for rampup", name, cp.MaxingPowerForDLMCycles, dlmrampupfromstandby)
-				}
-				if cp.Currents.L1 < 6 && cp.Currents.L2 < 6 && cp.Currents.L3 < 6 && !cp.Connectors[1].OnlyStandby {
-					//Not in standby current mode, but using less than 6 Amps
-					cp.UsingLessThan6AForDLMCycles++
-					log.Printf("%v using less than 6A, putting them into stanby 6A mode after they continue that for %v times", name, timetostandbyvehicle)
-					if cp.UsingLessThan6AForDLMCycles > timetostandbyvehicle {
-						log.Printf("Putting %v into standby after only using less than 6 Amps", name)
-						cp.Connectors[1].OnlyStandby = true //6A standby Current
-					}
-				}
-
-				if cp.Currents.L1 < cp.CurrentAssigned.L1-dlmrampdownafterunusedcurrent && cp.Currents.L2 < cp.CurrentAssigned.L2-dlmrampdownafterunusedcurrent && cp.Currents.L3 < cp.CurrentAssigned.L3-dlmrampdownafterunusedcurrent && !cp.Connectors[1].OnlyStandby {
-					//Car isn't using 100% of its assigned power from the station ( 2 less than assigned
-					if cp.NotUsingMaxForDLMCycles > dlmrampdownafterunusedcurrentfor {
-						if cp.Currents.L1+rampdowntocurrentoffset > 6 || cp.Currents.L2+rampdowntocurrentoffset > 6 || cp.Currents.L3+rampdowntocurrentoffset > 6 {
-							//Car doesn't use maximum full Power and is not using less than or equal 5A
-							log.Printf("%v has been ramped down to (%v/%v/%v)A", name, cp.Currents.L1+rampdowntocurrentoffset, cp.Currents.L2+rampdowntocurrentoffset, cp.Currents.L3+rampdowntocurrentoffset)
-							cp.CurrentTargeted.L1 = cp.Currents.L1 + rampdowntocurrentoffset
-							cp.CurrentTargeted.L2 = cp.Currents.L2 + rampdowntocurrentoffset
-							cp.CurrentTargeted.L3 = cp.Currents.L3 + rampdowntocurrentoffset
-							cp.ReducedPowerOfferring = true
-							grouppower := newpower[groupid]
-							grouppower["group"] += cp.CurrentTargeted.L1
-							handler.ResetDLM(name)
-						} else {
-							//Car is using 5A or less
-							cp.Connectors[1].OnlyStandby = true
-							log.Printf("%v went to standbycurrent from 2nd function, thats unuaual......")
-						}
-					} else {
-						cp.NotUsingMaxForDLMCycles++
-						log.Printf("%v is not using maximum Power for %v/%v cycles, they will soon be ramped down", name, cp.NotUsingMaxForDLMCycles, dlmrampdownafterunusedcurrentfor)
-					}
-				} else {
-					//Car uses Assigned power
-					if cp.NotUsingMaxForDLMCycles > 0 {
-						log.Printf("%v is over the threshold again, resetting counter")
-					}
-					cp.NotUsingMaxForDLMCycles = 0
-				}
-
-				if (cp.Currents.L1 == cp.CurrentOffered || cp.Currents.L2 == cp.CurrentOffered || cp.Currents.L3 == cp.CurrentOffered) && cp.ReducedPowerOfferring && !cp.Connectors[1].OnlyStandby {
-					//Car using all of its assigned power, and power offering to station is reduced
-					if cp.CurrentOffered == cp.CurrentTargeted.L1 || cp.CurrentOffered == cp.CurrentTargeted.L2 || cp.CurrentOffered == cp.CurrentTargeted.L3 {
-						//Internal Dlm of station has ramped up to 100% of its assigned current
-						if cp.MaxingPowerForDLMCycles >= dlmrampupfromstandby {
-							//Car does use maximum full Power, time to recheck
-							wantsfullpower[groupid][name] = true
-							cp.ReducedPowerOfferring = false
-							handler.ResetDLM(name)
-						} else {
-							cp.MaxingPowerForDLMCycles++
-							log.Printf("%v is maxing assigned power and station assigned cap is maxed, %v/%v times left before rampup", name, cp.MaxingPowerForDLMCycles, dlmrampupfromstandby)
-						}
-					} else {
-						//Power offering is reduced and car is using all of the stations assigned power, but station isnt offering all of its power yet
-
-					}
-				}
-				//Normal (full load) DLM after here
-				if !cp.Connectors[1].OnlyStandby && !cp.Connectors[1].DoneCharging && !cp.ReducedPowerOfferring {
-					wantsfullpower[groupid][name] = true
-				}
-
-			} else {
-				//Car only plugged in, but not using any power
-				if cp.CurrentTargeted.L1 != 6 {
-					log.Printf("%v stopped charging, removing power assignment", name)
-					cp.CurrentTargeted.L1 = 6
-					cp.CurrentTargeted.L2 = 6
-					cp.CurrentTargeted.L3 = 6
-				}
-			}
+		for _, id := range cp.dlmConnectorIDs() {
+			handler.rampUpConnector(connectorRef{name, id}, wantsfullpower, newpower)
 		}
 		if debugHearthBeat {
 			time.Sleep(2 * time.Millisecond)
 		}
-		if handler.ChargePoints[name].EVforDLMCycles > 10 && handler.ChargePoints[name].Connectors[1].Status == "SuspendedEV" {
-			handler.ChargePoints[name].Connectors[1].DoneCharging = true
-			handler.ChargePoints[name].Connectors[1].OnlyStandby = true
-		} else if handler.ChargePoints[name].Connectors[1].Status == "SuspendedEV" {
-			handler.ChargePoints[name].EVforDLMCycles++
-		} else {
-			handler.ChargePoints[name].EVforDLMCycles = 0
-		}
-
 	}
 	log.Println(wantsfullpower)
 	log.Println("---------------------------------DLMCollectorEnd--------------------------------------")
@@ -270,9 +292,13 @@ func (handler *CentralSystemHandler) RampUpPower() {
 	for groupname, group := range handler.Groups {
 		reducedofferings[groupname] = 0
 		for name, active := range group.Chargers {
-			if active == "true" && !handler.ChargePoints[name].Connectors[1].DoneCharging {
-				if handler.ChargePoints[name].ReducedPowerOfferring {
-					reducedofferings[groupname] += handler.ChargePoints[name].CurrentTargeted.L1
+			cp, ok := handler.ChargePoints[name]
+			if active != "true" || !ok {
+				continue
+			}
+			for _, connector := range cp.Connectors {
+				if !connector.DoneCharging && connector.ReducedPowerOfferring {
+					reducedofferings[groupname] += connector.CurrentTargeted.L1
 				}
 			}
 		}
@@ -291,20 +317,20 @@ func (handler *CentralSystemHandler) RampUpPower() {
 		}
 		if acivechargers != 0 {
 			medianavailable := groupavailablecurrent / acivechargers
-			if medianavailable > 16 {
-				medianavailable = 16
+			if medianavailable > dlmmaxcurrent {
+				medianavailable = dlmmaxcurrent
 			}
 			if debugHearthBeat {
 				log.Printf("------------- MaxPowerDLM - Group %v -------------------", groupid)
 				log.Printf("  MedianPower: %v", medianavailable)
 
 			}
-			for name, _ := range chargermap {
-				handler.ChargePoints[name].CurrentTargeted.L1 = medianavailable
-				handler.ChargePoints[name].CurrentTargeted.L2 = medianavailable
-				handler.ChargePoints[name].CurrentTargeted.L3 = medianavailable
+			shares := handler.socShares(chargermap, groupavailablecurrent)
+			for ref, share := range shares {
+				connector := handler.ChargePoints[ref.ChargePoint].getConnector(ref.Connector)
+				connector.setTargeted(share)
 				if debugHearthBeat {
-					log.Printf("  Startion %v Power: %v", name, medianavailable)
+					log.Printf("  Startion %v Power: %v", ref, connector.CurrentTargeted.L1)
 				}
 			}
 		} else {
@@ -317,9 +343,151 @@ func (handler *CentralSystemHandler) RampUpPower() {
 	return
 }
 
-func (handler *CentralSystemHandler) ResetDLM(chargePointID string) {
-	log.Printf("Resetting DLM Counters for %v", chargePointID)
-	handler.ChargePoints[chargePointID].MaxingPowerForDLMCycles = 0
-	handler.ChargePoints[chargePointID].NotUsingMaxForDLMCycles = 0
-	handler.ChargePoints[chargePointID].UsingLessThan6AForDLMCycles = 0
+// rampUpConnector collects what a connector wants from its group, standby and reduced offerings are assigned right away
+func (handler *CentralSystemHandler) rampUpConnector(ref connectorRef, wantsfullpower map[string]map[connectorRef]bool, newpower map[string]map[string]int) {
+	cp := handler.ChargePoints[ref.ChargePoint]
+	connector := cp.getConnector(ref.Connector)
+	if cp.Status == "Available" { //Not shut down
+		groupid := cp.DLMGroup
+		if !connector.DoneCharging {
+			//Method for giving standby Power
+			if connector.OnlyStandby && !connector.DoneCharging {
+				connector.setTargeted(6)
+				grouppower := newpower[groupid]
+				connector.ReducedPowerOfferring = true
+				grouppower["group"] += connector.CurrentTargeted.L1
+				handler.Groups[groupid].DLMActionPending = true
+			}
+
+			//Method for detecting Repower after standby has been detected
+			if connector.OnlyStandby && connector.MaxingPowerForDLMCycles > dlmrampupfromstandby {
+				connector.OnlyStandby = false
+				handler.ResetDLM(ref.ChargePoint, ref.Connector)
+				log.Printf("%v wants more power, pulling them out of stanby 6A mode after they maxed that for %v times", ref, dlmrampupfromstandby)
+				//Car wants moar powaaarrr, assume that charging limit was increased, thus we assume it as normal charging at full rate
+				//wantsfullpower[groupid][ref] = true
+				connector.setTargeted(8)
+				grouppower := newpower[groupid]
+				connector.ReducedPowerOfferring = true
+				grouppower["group"] += connector.CurrentTargeted.L1
+			} else if connector.OnlyStandby && (connector.Currents.L1 > dlmrampupfromstandbyaftercurrent || connector.Currents.L2 > dlmrampupfromstandbyaftercurrent || connector.Currents.L3 > dlmrampupfromstandbyaftercurrent) {
+				connector.MaxingPowerForDLMCycles = connector.MaxingPowerForDLMCycles + 1
+				log.Printf("%v maxing standby 6A, waiting for total %v/%v cycles for rampup", ref, connector.MaxingPowerForDLMCycles, dlmrampupfromstandby)
+			}
+			if connector.Currents.L1 < 6 && connector.Currents.L2 < 6 && connector.Currents.L3 < 6 && !connector.OnlyStandby {
+				//Not in standby current mode, but using less than 6 Amps
+				connector.UsingLessThan6AForDLMCycles++
+				log.Printf("%v using less than 6A, putting them into stanby 6A mode after they continue that for %v times", ref, timetostandbyvehicle)
+				if connector.UsingLessThan6AForDLMCycles > timetostandbyvehicle {
+					log.Printf("Putting %v into standby after only using less than 6 Amps", ref)
+					connector.OnlyStandby = true //6A standby Current
+				}
+			}
+
+			if connector.Currents.L1 < connector.CurrentAssigned.L1-dlmrampdownafterunusedcurrent && connector.Currents.L2 < connector.CurrentAssigned.L2-dlmrampdownafterunusedcurrent && connector.Currents.L3 < connector.CurrentAssigned.L3-dlmrampdownafterunusedcurrent && !connector.OnlyStandby {
+				//Car isn't using 100% of its assigned power from the station ( 2 less than assigned
+				if connector.NotUsingMaxForDLMCycles > dlmrampdownafterunusedcurrentfor {
+					if connector.Currents.L1+rampdowntocurrentoffset > 6 || connector.Currents.L2+rampdowntocurrentoffset > 6 || connector.Currents.L3+rampdowntocurrentoffset > 6 {
+						//Car doesn't use maximum full Power and is not using less than or equal 5A
+						log.Printf("%v has been ramped down to (%v/%v/%v)A", ref, connector.Currents.L1+rampdowntocurrentoffset, connector.Currents.L2+rampdowntocurrentoffset, connector.Currents.L3+rampdowntocurrentoffset)
+						connector.CurrentTargeted.L1 = connector.capCurrent(connector.Currents.L1 + rampdowntocurrentoffset)
+						connector.CurrentTargeted.L2 = connector.capCurrent(connector.Currents.L2 + rampdowntocurrentoffset)
+						connector.CurrentTargeted.L3 = connector.capCurrent(connector.Currents.L3 + rampdowntocurrentoffset)
+						connector.ReducedPowerOfferring = true
+						grouppower := newpower[groupid]
+						grouppower["group"] += connector.CurrentTargeted.L1
+						handler.ResetDLM(ref.ChargePoint, ref.Connector)
+					} else {
+						//Car is using 5A or less
+						connector.OnlyStandby = true
+						log.Printf("%v went to standbycurrent from 2nd function, thats unuaual......", ref)
+					}
+				} else {
+					connector.NotUsingMaxForDLMCycles++
+					log.Printf("%v is not using maximum Power for %v/%v cycles, they will soon be ramped down", ref, connector.NotUsingMaxForDLMCycles, dlmrampdownafterunusedcurrentfor)
+				}
+			} else {
+				//Car uses Assigned power
+				if connector.NotUsingMaxForDLMCycles > 0 {
+					log.Printf("%v is over the threshold again, resetting counter", ref)
+				}
+				connector.NotUsingMaxForDLMCycles = 0
+			}
+
+			if (connector.Currents.L1 == connector.CurrentOffered || connector.Currents.L2 == connector.CurrentOffered || connector.Currents.L3 == connector.CurrentOffered) && connector.ReducedPowerOfferring && !connector.OnlyStandby {
+				//Car using all of its assigned power, and power offering to station is reduced
+				if connector.CurrentOffered == connector.CurrentTargeted.L1 || connector.CurrentOffered == connector.CurrentTargeted.L2 || connector.CurrentOffered == connector.CurrentTargeted.L3 {
+					//Internal Dlm of station has ramped up to 100% of its assigned current
+					if connector.MaxingPowerForDLMCycles >= dlmrampupfromstandby {
+						//Car does use maximum full Power, time to recheck
+						wantsfullpower[groupid][ref] = true
+						connector.ReducedPowerOfferring = false
+						handler.ResetDLM(ref.ChargePoint, ref.Connector)
+					} else {
+						connector.MaxingPowerForDLMCycles++
+						log.Printf("%v is maxing assigned power and station assigned cap is maxed, %v/%v times left before rampup", ref, connector.MaxingPowerForDLMCycles, dlmrampupfromstandby)
+					}
+				} else {
+					//Power offering is reduced and car is using all of the stations assigned power, but station isnt offering all of its power yet
+
+				}
+			}
+			//Normal (full load) DLM after here
+			if !connector.OnlyStandby && !connector.DoneCharging && !connector.ReducedPowerOfferring {
+				wantsfullpower[groupid][ref] = true
+			}
+
+		} else {
+			//Car only plugged in, but not using any power
+			if connector.CurrentTargeted.L1 != 6 {
+				log.Printf("%v stopped charging, removing power assignment", ref)
+				connector.setTargeted(6)
+			}
+		}
+	}
+	if connector.EVforDLMCycles > 10 && connector.Status == "SuspendedEV" {
+		connector.DoneCharging = true
+		connector.OnlyStandby = true
+		handler.markIdle(ref.ChargePoint, connector)
+	} else if connector.Status == "SuspendedEV" {
+		connector.EVforDLMCycles++
+	} else {
+		connector.EVforDLMCycles = 0
+	}
+}
+
+// ResetDLM resets the DLM counters of a connector, connector 0 resets all connectors of the charger
+func (handler *CentralSystemHandler) ResetDLM(chargePointID string, connectorID int) {
+	log.Printf("Resetting DLM Counters for %v", connectorRef{chargePointID, connectorID})
+	for id, connector := range handler.ChargePoints[chargePointID].Connectors {
+		if connectorID == 0 || id == connectorID {
+			connector.MaxingPowerForDLMCycles = 0
+			connector.NotUsingMaxForDLMCycles = 0
+			connector.UsingLessThan6AForDLMCycles = 0
+		}
+	}
+}
+
+// SetConnectorLimit Http-RPC, the most current in A the DLM gives a connector, 0 removes the limit
+func (handler *CentralSystemHandler) SetConnectorLimit(chargePointID string, connectorID int, limit int) error {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return err
+	}
+	if _, ok := cp.Connectors[connectorID]; !ok || connectorID <= 0 {
+		return fmt.Errorf("unknown connector %v of %v", connectorID, chargePointID)
+	}
+	if limit < 0 {
+		return fmt.Errorf("limit can't be negative")
+	}
+	connector := cp.Connectors[connectorID]
+	connector.MaxCurrent = limit
+	if limit > 0 && connector.CurrentTargeted.L1 > limit {
+		connector.setTargeted(limit)
+		if group, ok := handler.Groups[cp.DLMGroup]; ok {
+			group.DLMActionPending = true
+		}
+	}
+	log.WithField("client", chargePointID).Infof("connector %v limited to %v A", connectorID, limit)
+	return nil
 }
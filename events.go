@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+const (
+	EventAudienceDriver   = "driver"
+	EventAudienceOperator = "operator"
+)
+
+// Event is a notification for the operator or a driver, it's kept in the event list and posted to the webhook
+type Event struct {
+	Id            int             `json:"id"`
+	Time          *types.DateTime `json:"time"`
+	Type          string          `json:"type"`
+	Audience      string          `json:"audience"`
+	ChargePointID string          `json:"charge_point_id"`
+	ConnectorId   int             `json:"connector_id"`
+	TransactionId int             `json:"transaction_id"`
+	IdTag         string          `json:"id_tag"`
+	Owner         string          `json:"owner"`
+	Message       string          `json:"message"`
+}
+
+// raiseEvent stores an event, the oldest ones are dropped beyond maxevents
+func (handler *CentralSystemHandler) raiseEvent(event Event) {
+	handler.NextEventID++
+	event.Id = handler.NextEventID
+	event.Time = types.NewDateTime(time.Now())
+	if auth, exists := lookupIdentity(event.IdTag); exists && event.Owner == "" {
+		event.Owner = auth.Owner
+	}
+	handler.Events = append(handler.Events, &event)
+	if len(handler.Events) > maxevents {
+		handler.Events = handler.Events[len(handler.Events)-maxevents:]
+	}
+	log.WithField("client", event.ChargePointID).Warnf("%v event for %v: %v", event.Type, event.Audience, event.Message)
+	if handler.EventWebhookURL != "" {
+		go func(url string) {
+			if err := postJSON(url, event); err != nil {
+				log.Printf("couldn't post event %v to webhook: %v", event.Id, err)
+			}
+		}(handler.EventWebhookURL)
+	}
+}
+
+// postJSON posts an object as json and expects a 2xx reply
+func postJSON(url string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: confirmationtimeout * time.Second}
+	response, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook replied %v", response.Status)
+	}
+	return nil
+}
+
+// GetEvents Http-RPC, events with an id above afterID
+func (handler *CentralSystemHandler) GetEvents(afterID int) []*Event {
+	list := []*Event{}
+	for _, event := range handler.Events {
+		if event.Id > afterID {
+			list = append(list, event)
+		}
+	}
+	return list
+}
+
+// SetEventWebhook Http-RPC, every event is posted there as json, empty disables it
+func (handler *CentralSystemHandler) SetEventWebhook(url string) string {
+	handler.EventWebhookURL = url
+	return "true"
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Built-in file server on the API port, chargers download firmware images from it
+
+type uploadReply struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func (handler *CentralSystemHandler) fileServerURL() string {
+	return strings.TrimRight(handler.FileServerURL, "/")
+}
+
+// requireFileServerURL fails when no URL is set, there is no default a charger could reach
+func (handler *CentralSystemHandler) requireFileServerURL() (string, error) {
+	if handler.FileServerURL == "" {
+		return "", fmt.Errorf("file server URL not configured, use setFileServerURL")
+	}
+	return handler.fileServerURL(), nil
+}
+
+// SetFileServerURL Http-RPC, the base URL chargers use to reach this server, e.g. http://10.0.0.2:8080
+func (handler *CentralSystemHandler) SetFileServerURL(url string) string {
+	handler.FileServerURL = url
+	return handler.fileServerURL()
+}
+
+// cleanFileName rejects names that would leave the storage directory
+func cleanFileName(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid file name %v", name)
+	}
+	return name, nil
+}
+
+// storeFile writes the request body into dir/name, returns size and sha256
+func storeFile(dir string, name string, body io.Reader) (*uploadReply, error) {
+	name, err := cleanFileName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), body)
+	if err != nil {
+		// no partial files, e.g. of uploads above the size limit
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	return &uploadReply{Name: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// apiAuth requires the API token as bearer token once one is set
+func (handler *CentralSystemHandler) apiAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if handler.APIToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(handler.APIToken)) != 1 {
+				log.Printf("API request from %v with invalid token", r.RemoteAddr)
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// SetAPIToken Http-RPC, an empty token leaves the API open and disables firmware uploads
+func (handler *CentralSystemHandler) SetAPIToken(token string) string {
+	handler.APIToken = token
+	return "true"
+}
+
+// firmwareUpload stores an image, uploads are refused as long as the API is open
+func (handler *CentralSystemHandler) firmwareUpload(w http.ResponseWriter, r *http.Request) {
+	if handler.APIToken == "" {
+		log.Printf("firmware upload from %v refused, no API token set", r.RemoteAddr)
+		http.Error(w, "no API token set, use setAPIToken", http.StatusForbidden)
+		return
+	}
+	upload, err := storeFile(firmwaredir, mux.Vars(r)["name"], r.Body)
+	if err != nil {
+		log.Printf("firmware upload from %v failed: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("firmware image %v uploaded (%v bytes, sha256 %v)", upload.Name, upload.Size, upload.SHA256)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_ = json.NewEncoder(w).Encode(upload)
+}
+
+func firmwareImageExists(name string) bool {
+	name, err := cleanFileName(name)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(firmwaredir, name))
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/core"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+// isFreeVend tells if a charger accepts every id tag, set on the charger or its DLM group
+func (handler *CentralSystemHandler) isFreeVend(chargePointID string) bool {
+	cp, ok := handler.ChargePoints[chargePointID]
+	if !ok {
+		return false
+	}
+	if cp.FreeVend {
+		return true
+	}
+	group, ok := handler.Groups[cp.DLMGroup]
+	return ok && group.FreeVend
+}
+
+// authorizeOnChargePoint is authorizeIdTag with the OCPI tokens of a published charger and the free vend
+// policy of the charger applied, free vend accepts unknown id tags only, blocked and expired ones stay rejected
+func (handler *CentralSystemHandler) authorizeOnChargePoint(chargePointID string, idTag string) (*types.IdTagInfo, string) {
+	if _, local := lookupIdentity(idTag); !local {
+		if info, reason, ok := handler.authorizeOCPIToken(chargePointID, idTag); ok {
+			return info, reason
+		}
+	}
+	info, reason := handler.authorizeIdTag(idTag)
+	if info.Status == types.AuthorizationStatusInvalid && handler.isFreeVend(chargePointID) {
+		return &types.IdTagInfo{Status: types.AuthorizationStatusAccepted, ParentIdTag: info.ParentIdTag}, reason + ", accepted by free vend"
+	}
+	return info, reason
+}
+
+// SetFreeVend Http-RPC, target is "group" or "chargepoint"
+func (handler *CentralSystemHandler) SetFreeVend(target string, id string, enabled bool) error {
+	switch target {
+	case "group":
+		group, ok := handler.Groups[id]
+		if !ok {
+			return fmt.Errorf("unknown group %v", id)
+		}
+		group.FreeVend = enabled
+	case "chargepoint":
+		cp, err := handler.chargePointByID(id)
+		if err != nil {
+			return err
+		}
+		cp.FreeVend = enabled
+	default:
+		return fmt.Errorf("unknown target %v, use group or chargepoint", target)
+	}
+	log.Printf("free vend for %v %v: %v", target, id, enabled)
+	return nil
+}
+
+// GetFlaggedTransactions Http-RPC, transactions started by id tags that aren't in the list
+func (handler *CentralSystemHandler) GetFlaggedTransactions() []*TransactionInfo {
+	list := []*TransactionInfo{}
+	for _, transaction := range handler.Transactions {
+		if transaction.UnknownIdTag {
+			list = append(list, transaction)
+		}
+	}
+	return list
+}
+
+// stopUnauthorizedTransaction stops a refused transaction in case the charger keeps it running
+// (StopTransactionOnInvalidId disabled)
+func (handler *CentralSystemHandler) stopUnauthorizedTransaction(chargePointID string, transactionID int) {
+	time.Sleep(stopunauthorizedafter * time.Second)
+	transaction, ok := handler.Transactions[transactionID]
+	if !ok || transaction.hasTransactionEnded() {
+		return
+	}
+	logDefault(chargePointID, core.RemoteStopTransactionFeatureName).Warnf("transaction %v still running without authorization, stopping it", transactionID)
+	handler.remoteStopTransaction(chargePointID, transactionID)
+}
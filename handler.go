@@ -3,9 +3,10 @@ package main
 import (
 	"fmt"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
+	"github.com/lorenzodonini/ocpp-go/ocpp"
 	"github.com/lorenzodonini/ocpp-go/ocpp1.6/remotetrigger"
 	"github.com/sirupsen/logrus"
 
@@ -30,17 +31,33 @@ type Group struct {
 	Offered3Phase          int               `json:"offered_3phase"`
 	Initialized            bool              `json:"initialized"`
 	AvarageAssignedCurrent int               `json:"avarage_assigned_current"`
+	ConfigProfile          string            `json:"config_profile"`
+	FreeVend               bool              `json:"free_vend"`
+	Tariff                 string            `json:"tariff"`
 }
 
 // TransactionInfo contains info about a transaction
 type TransactionInfo struct {
-	Id          int             `json:"id"`
-	StartTime   *types.DateTime `json:"start_time"`
-	EndTime     *types.DateTime `json:"end_time"`
-	StartMeter  int             `json:"start_meter"`
-	EndMeter    int             `json:"end_meter"`
-	ConnectorId int             `json:"connector_id"`
-	IdTag       string          `json:"id_tag"`
+	Id                  int                       `json:"id"`
+	StartTime           *types.DateTime           `json:"start_time"`
+	EndTime             *types.DateTime           `json:"end_time"`
+	StartMeter          int                       `json:"start_meter"`
+	EndMeter            int                       `json:"end_meter"`
+	ConnectorId         int                       `json:"connector_id"`
+	IdTag               string                    `json:"id_tag"`
+	ReservationId       int                       `json:"reservation_id"`
+	AuthorizationStatus types.AuthorizationStatus `json:"authorization_status"`
+	UnknownIdTag        bool                      `json:"unknown_id_tag"`
+	ChargePointID       string                    `json:"charge_point_id"`
+	QuotaStopped        bool                      `json:"quota_stopped"`
+	StopIdTag           string                    `json:"stop_id_tag,omitempty"`
+	IdleSince           *types.DateTime           `json:"idle_since,omitempty"`
+	StartSoC            *float64                  `json:"start_soc,omitempty"`
+	SoC                 *float64                  `json:"soc,omitempty"`
+	SoCUpdated          *types.DateTime           `json:"soc_updated,omitempty"`
+	TargetSoCStopped    bool                      `json:"target_soc_stopped"`
+	SignedValues        []*SignedValue            `json:"signed_values,omitempty"`
+	Anomalies           []string                  `json:"anomalies,omitempty"`
 }
 
 func (ti *TransactionInfo) hasTransactionEnded() bool {
@@ -55,6 +72,27 @@ type ConnectorInfo struct {
 	CurrentTransaction int                    `json:"current_transaction"`
 	DoneCharging       bool                   `json:"done_charging"`
 	OnlyStandby        bool                   `json:"only_standby"`
+	ReservationId      int                    `json:"reservation_id"`
+	ReservedIdTag      string                 `json:"reserved_id_tag"`
+	ReservedUntil      *types.DateTime        `json:"reserved_until"`
+	IdleSince          *types.DateTime        `json:"idle_since"`
+	IdleNotified       bool                   `json:"idle_notified"`
+	IdleEscalated      bool                   `json:"idle_escalated"`
+	// DLM state of the connector, MaxCurrent is its limit in A and QuotaCap the limit of a session
+	// close to its energy quota (0 for none)
+	Currents                    PortCurrents `json:"currents"`
+	CurrentOffered              int          `json:"current_offered"`
+	EnergyMeterCurrent          int64        `json:"energy_meter_current"`
+	CurrentAssigned             PortCurrents `json:"current_assigned"`
+	CurrentTargeted             PortCurrents `json:"current_targeted"`
+	MaxCurrent                  int          `json:"max_current"`
+	QuotaCap                    int          `json:"quota_cap"`
+	EVforDLMCycles              int          `json:"evfor_dlm_cycles"`
+	OfflineForDLMCycles         int          `json:"offline_for_dlm_cycles"`
+	ReducedPowerOfferring       bool         `json:"reduced_power_offerring"`
+	MaxingPowerForDLMCycles     int          `json:"maxing_power_for_dlm_cycles"`
+	NotUsingMaxForDLMCycles     int          `json:"not_using_max_for_dlm_cycles"`
+	UsingLessThan6AForDLMCycles int          `json:"using_less_than_6a_for_dlm_cycles"`
 }
 
 type PortCurrents struct {
@@ -63,6 +101,13 @@ type PortCurrents struct {
 	L3 int `json:"l3"`
 }
 
+// PhaseReadings are decimal values per phase
+type PhaseReadings struct {
+	L1 float64 `json:"l1"`
+	L2 float64 `json:"l2"`
+	L3 float64 `json:"l3"`
+}
+
 type PortPower struct {
 	L1    int `json:"l1"`
 	L2    int `json:"l2"`
@@ -79,29 +124,41 @@ func (ci *ConnectorInfo) hasTransactionInProgress() bool {
 //	Transactions []TransactionInfo
 //}
 
-// ChargePointState contains all relevant state data for a connected charge point, simplified only working with single-connector chargepoints
+// ChargePointState contains all relevant state data for a connected charge point, the DLM state is kept per connector
 type ChargePointState struct {
-	EVforDLMCycles              int                    `json:"evfor_dlm_cycles"`
-	EVSEForDLMCycles            int                    `json:"evse_for_dlm_cycles"`
-	OfflineForDLMCycles         int                    `json:"offline_for_dlm_cycles"`
-	ReducedPowerOfferring       bool                   `json:"reduced_power_offerring"`
-	MaxingPowerForDLMCycles     int                    `json:"maxing_power_for_dlm_cycles"`
-	NotUsingMaxForDLMCycles     int                    `json:"not_using_max_for_dlm_cycles"`
-	UsingLessThan6AForDLMCycles int                    `json:"using_less_than_6a_for_dlm_cycles"`
-	Rotation                    string                 `json:"rotation"`
-	Status                      core.ChargePointStatus `json:"status"`
-	diagnosticsStatus           firmware.DiagnosticsStatus
-	firmwareStatus              firmware.FirmwareStatus
-	DLMGroup                    string                 `json:"dlm_group"`
-	Connectors                  map[int]*ConnectorInfo `json:"connectors"`
-	Currents                    PortCurrents           `json:"currents"`
-	CurrentAssigned             PortCurrents           `json:"current_assigned"`
-	CurrentTargeted             PortCurrents           `json:"current_targeted"`
-	CurrentOffered              int                    `json:"current_offered"`
-	Power                       PortPower              `json:"power"`
-	EnergyMeterCurrent          int64                  `json:"energy_meter_current"`
-	lastTimeStamp               *types.DateTime
-	ErrorCode                   core.ChargePointErrorCode `json:"error_code"`
+	Rotation             string                     `json:"rotation"`
+	Status               core.ChargePointStatus     `json:"status"`
+	DiagnosticsStatus    firmware.DiagnosticsStatus `json:"diagnostics_status"`
+	Diagnostics          []*DiagnosticsRecord       `json:"diagnostics"`
+	FirmwareStatus       firmware.FirmwareStatus    `json:"firmware_status"`
+	DLMGroup             string                     `json:"dlm_group"`
+	Connectors           map[int]*ConnectorInfo     `json:"connectors"`
+	Currents             PortCurrents               `json:"currents"`
+	CurrentOffered       int                        `json:"current_offered"`
+	Power                PortPower                  `json:"power"`
+	EnergyMeterCurrent   int64                      `json:"energy_meter_current"`
+	EnergyExportRegister float64                    `json:"energy_export_register"`
+	Voltage              PhaseReadings              `json:"voltage"`
+	Temperature          float64                    `json:"temperature"`
+	SoC                  float64                    `json:"soc"`
+	PowerOffered         float64                    `json:"power_offered"`
+	MeterReadings        map[string]float64         `json:"meter_readings"`
+	MeterValueErrors     int                        `json:"meter_value_errors"`
+	LastMeterValueError  string                     `json:"last_meter_value_error"`
+	MeterPublicKey       string                     `json:"meter_public_key"`
+	Plausibility         *PlausibilityRules         `json:"plausibility,omitempty"`
+	Anomalies            []*Anomaly                 `json:"anomalies"`
+	MeterFlagged         bool                       `json:"meter_flagged"`
+	lastTimeStamp        *types.DateTime
+	pendingRegisters     map[int]*pendingRegister
+	Boot                 BootInfo                        `json:"boot"`
+	Configuration        map[string]ConfigurationValue   `json:"configuration"`
+	ConfigurationUpdated *types.DateTime                 `json:"configuration_updated"`
+	ConfigProfile        string                          `json:"config_profile"`
+	ConfigurationResults map[string]*ConfigurationResult `json:"configuration_results"`
+	LocalList            LocalListState                  `json:"local_list"`
+	FreeVend             bool                            `json:"free_vend"`
+	ErrorCode            core.ChargePointErrorCode       `json:"error_code"`
 }
 
 func (cps *ChargePointState) getConnector(id int) *ConnectorInfo {
@@ -115,14 +172,37 @@ func (cps *ChargePointState) getConnector(id int) *ConnectorInfo {
 
 // CentralSystemHandler contains  state that central system wants to keep.
 type CentralSystemHandler struct {
-	ChargePoints            map[string]*ChargePointState `json:"charge_points"`
-	Groups                  map[string]*Group            `json:"groups"`
-	CurrentTotalL1          int                          `json:"current_total_l_1"`
-	CurrentTotalL2          int                          `json:"current_total_l_2"`
-	CurrentTotalL3          int                          `json:"current_total_l_3"`
-	GroupsInitialized       map[string]bool              `json:"groups_initialized"`
-	ChargePointsInitialized map[string]bool              `json:"charge_points_initialized"`
-	Transactions            map[int]*TransactionInfo     `json:"transactions"`
+	ChargePoints            map[string]*ChargePointState      `json:"charge_points"`
+	Groups                  map[string]*Group                 `json:"groups"`
+	CurrentTotalL1          int                               `json:"current_total_l_1"`
+	CurrentTotalL2          int                               `json:"current_total_l_2"`
+	CurrentTotalL3          int                               `json:"current_total_l_3"`
+	GroupsInitialized       map[string]bool                   `json:"groups_initialized"`
+	ChargePointsInitialized map[string]bool                   `json:"charge_points_initialized"`
+	Transactions            map[int]*TransactionInfo          `json:"transactions"`
+	Credentials             map[string]*ChargePointCredential `json:"credentials"`
+	ChargePointAuthRequired bool                              `json:"charge_point_auth_required"`
+	Registrations           map[string]*ChargerRegistration   `json:"registrations"`
+	RegistrationPolicy      RegistrationPolicy                `json:"registration_policy"`
+	ConfigProfiles          map[string]*ConfigProfile         `json:"config_profiles"`
+	Campaigns               map[int]*FirmwareCampaign         `json:"campaigns"`
+	NextCampaignID          int                               `json:"next_campaign_id"`
+	FileServerURL           string                            `json:"file_server_url"`
+	APIToken                string                            `json:"api_token"`
+	Reservations            map[int]*Reservation              `json:"reservations"`
+	NextReservationID       int                               `json:"next_reservation_id"`
+	LocalList               map[string]types.IdTagInfo        `json:"local_list"`
+	LocalListVersion        int                               `json:"local_list_version"`
+	Pairings                map[string]*PairingSession        `json:"pairings"`
+	Tariffs                 map[string]*Tariff                `json:"tariffs"`
+	CDRs                    map[int]*CDR                      `json:"cdrs"`
+	OCPI                    OCPIConfig                        `json:"ocpi"`
+	Events                  []*Event                          `json:"events"`
+	NextEventID             int                               `json:"next_event_id"`
+	EventWebhookURL         string                            `json:"event_webhook_url"`
+	ocpiMock                *ocpiMockEMSP
+	setupStarted            map[string]bool
+	configMutex             sync.Mutex
 	version                 string
 	NextTransactionID       int `json:"next_transaction_id"`
 	debug                   bool
@@ -131,51 +211,27 @@ type CentralSystemHandler struct {
 // ------------- Core profile callbacks -------------
 
 func (handler *CentralSystemHandler) OnAuthorize(chargePointId string, request *core.AuthorizeRequest) (confirmation *core.AuthorizeConfirmation, err error) {
-	var authorized types.AuthorizationStatus
-	isMac := false
-	idwithoutMac := strings.Replace(request.IdTag, "MAC", "", -1)
-	log.Printf("ID_TAG: " + idwithoutMac)
-	if (string([]rune(request.IdTag)[0]) == "M") && (string([]rune(request.IdTag)[1]) == "A") && (string([]rune(request.IdTag)[2]) == "C") {
-		isMac = true
-	}
-
-	if isMac {
-		_, exists := identity.MACs[idwithoutMac]
-		if exists {
-			if identity.MACs[idwithoutMac].Authorized {
-				authorized = types.AuthorizationStatusAccepted
-				logDefault(chargePointId, request.GetFeatureName()).Infof("mac authorized")
-			} else {
-				authorized = types.AuthorizationStatusExpired
-				logDefault(chargePointId, request.GetFeatureName()).Infof("mac blocked")
-			}
-		} else {
-			authorized = types.AuthorizationStatusBlocked
-			logDefault(chargePointId, request.GetFeatureName()).Infof("mac not in list")
-		}
-
-	} else {
-		_, exists := identity.Cards[request.IdTag]
-		if exists {
-			if identity.Cards[request.IdTag].Authorized {
-				authorized = types.AuthorizationStatusAccepted
-				logDefault(chargePointId, request.GetFeatureName()).Infof("card authorized")
-			} else {
-				authorized = types.AuthorizationStatusExpired
-				logDefault(chargePointId, request.GetFeatureName()).Infof("card blocked")
-			}
-		} else {
-			authorized = types.AuthorizationStatusBlocked
-			logDefault(chargePointId, request.GetFeatureName()).Infof("card not in list")
-		}
-
+	if !handler.servesRequests(chargePointId) {
+		logDefault(chargePointId, request.GetFeatureName()).Warnf("%v refused, charge point not accepted", request.IdTag)
+		return core.NewAuthorizationConfirmation(types.NewIdTagInfo(types.AuthorizationStatusInvalid)), nil
 	}
-	go handler.ResetDLM(chargePointId)
-	return core.NewAuthorizationConfirmation(types.NewIdTagInfo(authorized)), nil
+	handler.handlePairing(chargePointId, request.IdTag)
+	info, reason := handler.authorizeOnChargePoint(chargePointId, request.IdTag)
+	logDefault(chargePointId, request.GetFeatureName()).Infof("%v %v: %v", request.IdTag, info.Status, reason)
+	go handler.ResetDLM(chargePointId, 0)
+	return core.NewAuthorizationConfirmation(info), nil
 }
 
 func (handler *CentralSystemHandler) OnBootNotification(chargePointId string, request *core.BootNotificationRequest) (confirmation *core.BootNotificationConfirmation, err error) {
-	logDefault(chargePointId, request.GetFeatureName()).Infof("boot confirmed")
+	reg := handler.registerBoot(chargePointId, request)
+	if cp, ok := handler.ChargePoints[chargePointId]; ok {
+		cp.Boot = newBootInfo(request)
+	}
+	logDefault(chargePointId, request.GetFeatureName()).Infof("boot of %v %v (serial %v, firmware %v) %v", reg.Vendor, reg.Model, reg.SerialNumber, reg.FirmwareVersion, reg.Status)
+	if reg.Status != core.RegistrationStatusAccepted {
+		return core.NewBootNotificationConfirmation(types.NewDateTime(time.Now()), pendingbootretryinterval, reg.Status), nil
+	}
+	handler.startSetupRoutine(chargePointId)
 	return core.NewBootNotificationConfirmation(types.NewDateTime(time.Now()), defaultHeartbeatInterval, core.RegistrationStatusAccepted), nil
 }
 
@@ -192,9 +248,19 @@ func (handler *CentralSystemHandler) OnHeartbeat(chargePointId string, request *
 }
 
 func (handler *CentralSystemHandler) OnMeterValues(chargePointId string, request *core.MeterValuesRequest) (confirmation *core.MeterValuesConfirmation, err error) {
+	if !handler.servesRequests(chargePointId) {
+		logDefault(chargePointId, request.GetFeatureName()).Warn("meter values ignored, charge point not accepted")
+		return core.NewMeterValuesConfirmation(), nil
+	}
 	if handler.debug {
 		logDefault(chargePointId, request.GetFeatureName()).Infof("received meter values for connector %v. Meter values:\n", request.ConnectorId)
 	}
+	transactionID := -1
+	if request.TransactionId != nil {
+		transactionID = *request.TransactionId
+	} else if connector, ok := handler.ChargePoints[chargePointId].Connectors[request.ConnectorId]; ok {
+		transactionID = connector.CurrentTransaction
+	}
 	for _, mv := range request.MeterValue { //expect that only one meterValue per request is sent
 		if handler.debug {
 			logDefault(chargePointId, request.GetFeatureName()).Printf("%v", mv)
@@ -211,37 +277,26 @@ func (handler *CentralSystemHandler) OnMeterValues(chargePointId string, request
 				log.Println("SVU--" + sv.Unit)
 				log.Println("---------------------------")
 			}
-			switch sv.Measurand {
-			case "Power.Active.Import":
-				switch sv.Phase {
-				case "L1":
-					handler.ChargePoints[chargePointId].Power.L1, _ = strconv.Atoi(sv.Value)
-				case "L2":
-					handler.ChargePoints[chargePointId].Power.L2, _ = strconv.Atoi(sv.Value)
-				case "L3":
-					handler.ChargePoints[chargePointId].Power.L3, _ = strconv.Atoi(sv.Value)
-				default:
-					handler.ChargePoints[chargePointId].Power.Total, _ = strconv.Atoi(sv.Value)
-				}
-			case "Current.Offered":
-				handler.ChargePoints[chargePointId].CurrentOffered, _ = strconv.Atoi(sv.Value)
-			case "Current.Import":
-				switch sv.Phase {
-				case "L1":
-					handler.ChargePoints[chargePointId].Currents.L1, _ = strconv.Atoi(sv.Value)
-				case "L2":
-					handler.ChargePoints[chargePointId].Currents.L2, _ = strconv.Atoi(sv.Value)
-				case "L3":
-					handler.ChargePoints[chargePointId].Currents.L3, _ = strconv.Atoi(sv.Value)
-				default:
-					log.Printf("Unexpected meterValue from %v", chargePointId)
+			reading, err := parseMeterValue(string(sv.Measurand), string(sv.Phase), string(sv.Unit), string(sv.Format), sv.Value)
+			if err != nil {
+				cp := handler.ChargePoints[chargePointId]
+				cp.MeterValueErrors++
+				cp.LastMeterValueError = err.Error()
+				logDefault(chargePointId, request.GetFeatureName()).Warnf("couldn't parse meter value: %v", err)
+				continue
+			}
+			if !reading.Signed && handler.checkReading(chargePointId, request.ConnectorId, transactionID, reading) {
+				handler.ChargePoints[chargePointId].applyMeterReading(reading)
+				if connector := handler.ChargePoints[chargePointId].meterConnector(request.ConnectorId); connector != nil {
+					connector.applyMeterReading(reading)
 				}
-			case "Energy.Active.Import.Register":
-				handler.ChargePoints[chargePointId].EnergyMeterCurrent, _ = strconv.ParseInt(sv.Value, 10, 64)
 			}
 
 		}
 	}
+	handler.storeMeterValues(chargePointId, request.ConnectorId, transactionID, request.MeterValue)
+	handler.updateTransactionSoC(chargePointId, transactionID, request.MeterValue)
+	handler.storeSignedValues(chargePointId, transactionID, request.MeterValue)
 	return core.NewMeterValuesConfirmation(), nil
 }
 
@@ -261,29 +316,21 @@ func (handler *CentralSystemHandler) OnStatusNotification(chargePointId string,
 			connectorInfo.DoneCharging = false
 		} else if request.Status == "Charging" && request.Info == "Energy is flowing to vehicle" {
 			connectorInfo.DoneCharging = false
+			handler.clearIdle(connectorInfo)
 		} else if request.Status == "Charging" && connectorInfo.DoneCharging {
-			handler.SetConfig(chargePointId, "DlmOperatorPhase1Limit", "0")
-			handler.SetConfig(chargePointId, "DlmOperatorPhase2Limit", "0")
-			handler.SetConfig(chargePointId, "DlmOperatorPhase3Limit", "0")
-			cp := handler.ChargePoints[chargePointId]
-			cp.CurrentAssigned.L1 = 0
-			cp.CurrentTargeted.L1 = 0
-			cp.CurrentAssigned.L2 = 0
-			cp.CurrentTargeted.L2 = 0
-			cp.CurrentAssigned.L3 = 0
-			cp.CurrentTargeted.L3 = 0
+			handler.applyConnectorLimit(chargePointId, request.ConnectorId, PortCurrents{})
+			connectorInfo.CurrentAssigned = PortCurrents{}
+			connectorInfo.CurrentTargeted = PortCurrents{}
 		} else if request.Status == "Available" {
-			cp := handler.ChargePoints[chargePointId]
-			cp.Power.L1 = 0
-			cp.Power.L2 = 0
-			cp.Power.L3 = 0
-			cp.Power.Total = 0
-			cp.Currents.L1 = 0
-			cp.Currents.L2 = 0
-			cp.Currents.L3 = 0
-			cp.MaxingPowerForDLMCycles = 0
+			if len(info.dlmConnectorIDs()) == 1 {
+				info.Power = PortPower{}
+				info.Currents = PortCurrents{}
+			}
+			connectorInfo.Currents = PortCurrents{}
+			connectorInfo.MaxingPowerForDLMCycles = 0
 			connectorInfo.OnlyStandby = false
 			connectorInfo.DoneCharging = true
+			handler.clearIdle(connectorInfo)
 		}
 		logDefault(chargePointId, request.GetFeatureName()).Infof("connector %v updated status to %v", request.ConnectorId, request.Status)
 		log.Println(request.Info)
@@ -299,12 +346,17 @@ func (handler *CentralSystemHandler) OnStartTransaction(chargePointId string, re
 	if !ok {
 		return nil, fmt.Errorf("unknown charge point %v", chargePointId)
 	}
+	if !handler.servesRequests(chargePointId) {
+		logDefault(chargePointId, request.GetFeatureName()).Warnf("transaction of %v refused, charge point not accepted", request.IdTag)
+		return nil, fmt.Errorf("charge point %v not accepted", chargePointId)
+	}
 	connector := info.getConnector(request.ConnectorId)
 	if connector.CurrentTransaction >= 0 {
 		return nil, fmt.Errorf("connector %v is currently busy with another transaction", request.ConnectorId)
 	}
 	transaction := &TransactionInfo{}
 	transaction.IdTag = request.IdTag
+	transaction.ChargePointID = chargePointId
 	transaction.ConnectorId = request.ConnectorId
 	transaction.StartMeter = request.MeterStart
 	transaction.StartTime = request.Timestamp
@@ -312,39 +364,31 @@ func (handler *CentralSystemHandler) OnStartTransaction(chargePointId string, re
 	handler.NextTransactionID += 1
 	connector.CurrentTransaction = transaction.Id
 	handler.Transactions[transaction.Id] = transaction
+	startSession(transaction)
 	//Authorization-Check
-	isMac := false
-	idwithoutMac := strings.Replace(request.IdTag, "MAC", "", -1)
-	log.Printf("ID_TAG: " + idwithoutMac)
-	if (string([]rune(request.IdTag)[0]) == "M") && (string([]rune(request.IdTag)[1]) == "A") && (string([]rune(request.IdTag)[2]) == "C") {
-		isMac = true
-	}
-
-	if isMac {
-		_, exists := identity.MACs[idwithoutMac]
-		if exists {
-			logDefault(chargePointId, request.GetFeatureName()).Infof("transaction mac authorized")
-		} else {
-			logDefault(chargePointId, request.GetFeatureName()).Infof("mac not in list")
-		}
-
-	} else {
-		_, exists := identity.Cards[request.IdTag]
-		if exists {
-			if identity.Cards[request.IdTag].Authorized {
-				logDefault(chargePointId, request.GetFeatureName()).Infof("transaction card authorized")
-			}
-		} else {
-			logDefault(chargePointId, request.GetFeatureName()).Infof("card not in list")
+	handler.handlePairing(chargePointId, request.IdTag)
+	idTagInfo, reason := handler.authorizeOnChargePoint(chargePointId, request.IdTag)
+	logDefault(chargePointId, request.GetFeatureName()).Infof("transaction %v %v: %v", request.IdTag, idTagInfo.Status, reason)
+	transaction.AuthorizationStatus = idTagInfo.Status
+	if request.ReservationId != nil {
+		transaction.ReservationId = *request.ReservationId
+		// a refused id tag gets its transaction stopped, the reservation stays for the one who made it
+		if idTagInfo.Status == types.AuthorizationStatusAccepted {
+			handler.consumeReservation(chargePointId, *request.ReservationId, transaction.Id, request.IdTag)
 		}
-
+	}
+	if _, known := lookupIdentity(request.IdTag); !known && !handler.isOCPIToken(request.IdTag) {
+		transaction.UnknownIdTag = true
+		logDefault(chargePointId, request.GetFeatureName()).Warnf("transaction %v started by unknown id tag %v", transaction.Id, request.IdTag)
+	}
+	if idTagInfo.Status != types.AuthorizationStatusAccepted {
+		go handler.stopUnauthorizedTransaction(chargePointId, transaction.Id)
 	}
 
-	//
 	logDefault(chargePointId, request.GetFeatureName()).Infof("started transaction %v for connector %v", transaction.Id, transaction.ConnectorId)
-	handler.ChargePoints[chargePointId].Connectors[1].OnlyStandby = true
-	handler.ChargePoints[chargePointId].Connectors[1].DoneCharging = false
-	return core.NewStartTransactionConfirmation(types.NewIdTagInfo(types.AuthorizationStatusAccepted), transaction.Id), nil
+	connector.OnlyStandby = true
+	connector.DoneCharging = false
+	return core.NewStartTransactionConfirmation(idTagInfo, transaction.Id), nil
 }
 
 func (handler *CentralSystemHandler) OnStopTransaction(chargePointId string, request *core.StopTransactionRequest) (confirmation *core.StopTransactionConfirmation, err error) {
@@ -355,34 +399,33 @@ func (handler *CentralSystemHandler) OnStopTransaction(chargePointId string, req
 	transaction, ok := handler.Transactions[request.TransactionId]
 	if ok {
 		connector := info.getConnector(transaction.ConnectorId)
-		connector.CurrentTransaction = -1
+		connector.OnlyStandby = false
+		connector.DoneCharging = true
+		connector.QuotaCap = 0
 		transaction.EndTime = request.Timestamp
+		// after EndTime, so the transaction keeps its idle time for the CDR
+		handler.clearIdle(connector)
+		connector.CurrentTransaction = -1
 		transaction.EndMeter = request.MeterStop
-		energyUsed := transaction.EndMeter - transaction.StartMeter
-		//Detect if idtag is mac or card
-		isMac := false
-		idwithoutMac := strings.Replace(request.IdTag, "MAC", "", -1)
-		log.Printf("ID_TAG: " + idwithoutMac)
-		if (string([]rune(request.IdTag)[0]) == "M") && (string([]rune(request.IdTag)[1]) == "A") && (string([]rune(request.IdTag)[2]) == "C") {
-			isMac = true
-		}
-		// isMac is true if it is a mac, idwithoutMac if just some form of id is needed
-		//writing used energy onto id
-		var tagident authIdStruct
-		if isMac { //billing to mac
-			tagident = identity.MACs[idwithoutMac]
-		} else { //billing to normal id-tag
-			tagident = identity.Cards[request.IdTag]
+		handler.checkMeterStop(chargePointId, transaction)
+		if request.IdTag != "" && request.IdTag != transaction.IdTag {
+			transaction.StopIdTag = request.IdTag
+			logDefault(chargePointId, request.GetFeatureName()).Infof("transaction %v started by %v stopped by %v", transaction.Id, transaction.IdTag, request.IdTag)
 		}
-		tagident.EnergyCharged += int64(energyUsed)
+		handler.storeMeterValues(chargePointId, transaction.ConnectorId, transaction.Id, request.TransactionData)
+		handler.updateTransactionSoC(chargePointId, transaction.Id, request.TransactionData)
+		handler.storeSignedValues(chargePointId, transaction.Id, request.TransactionData)
+		bookTransaction(transaction)
+		handler.createCDR(transaction)
+	} else {
+		logDefault(chargePointId, request.GetFeatureName()).Warnf("unknown transaction %v, energy not booked", request.TransactionId)
 	}
 	logDefault(chargePointId, request.GetFeatureName()).Infof("stopped transaction %v - %v", request.TransactionId, request.Reason)
-	for _, mv := range request.TransactionData {
-		logDefault(chargePointId, request.GetFeatureName()).Printf("%v", mv)
+	confirmation = core.NewStopTransactionConfirmation()
+	if request.IdTag != "" {
+		confirmation.IdTagInfo, _ = handler.authorizeOnChargePoint(chargePointId, request.IdTag)
 	}
-	handler.ChargePoints[chargePointId].Connectors[1].OnlyStandby = false
-	handler.ChargePoints[chargePointId].Connectors[1].DoneCharging = true
-	return core.NewStopTransactionConfirmation(), nil
+	return confirmation, nil
 }
 
 // ------------- Firmware management profile callbacks -------------
@@ -392,7 +435,10 @@ func (handler *CentralSystemHandler) OnDiagnosticsStatusNotification(chargePoint
 	if !ok {
 		return nil, fmt.Errorf("unknown charge point %v", chargePointId)
 	}
-	info.diagnosticsStatus = request.Status
+	info.DiagnosticsStatus = request.Status
+	if record := info.lastDiagnostics(); record != nil && record.Status != string(firmware.DiagnosticsStatusUploaded) {
+		record.Status = string(request.Status)
+	}
 	logDefault(chargePointId, request.GetFeatureName()).Infof("updated diagnostics status to %v", request.Status)
 	return firmware.NewDiagnosticsStatusNotificationConfirmation(), nil
 }
@@ -402,8 +448,9 @@ func (handler *CentralSystemHandler) OnFirmwareStatusNotification(chargePointId
 	if !ok {
 		return nil, fmt.Errorf("unknown charge point %v", chargePointId)
 	}
-	info.firmwareStatus = request.Status
+	info.FirmwareStatus = request.Status
 	logDefault(chargePointId, request.GetFeatureName()).Infof("updated firmware status to %v", request.Status)
+	handler.updateCampaignTarget(chargePointId, request.Status)
 	return &firmware.FirmwareStatusNotificationConfirmation{}, nil
 }
 
@@ -421,25 +468,42 @@ func (handler *CentralSystemHandler) GetSystemState() map[string]interface{} {
 	reply["chargePoints"] = handler.ChargePoints
 	reply["groups"] = handler.Groups
 	reply["identities"] = identity
-	reply["debug"] = handler
 	return reply
 }
 
-func (handler *CentralSystemHandler) SetChargePointRemoteStart(chargePointID string, idtag string) bool {
+// SetChargePointRemoteStart Http-RPC, connector 0 lets the charger choose the connector
+func (handler *CentralSystemHandler) SetChargePointRemoteStart(chargePointID string, idtag string, connectorID int) bool {
 	println(chargePointID)
 	callback3 := func(confirmation *core.RemoteStartTransactionConfirmation, err error) {
 		log.Println("Confirmation")
 	}
-	_ = centralSystem.RemoteStartTransaction(chargePointID, callback3, idtag)
+	_ = centralSystem.RemoteStartTransaction(chargePointID, callback3, idtag, func(request *core.RemoteStartTransactionRequest) {
+		if connectorID > 0 {
+			request.ConnectorId = &connectorID
+		}
+	})
 	return true
 }
 
-func (handler *CentralSystemHandler) SetChargePointRemoteStop(chargePointID string) bool {
-	println(chargePointID)
+// SetChargePointRemoteStop Http-RPC, connector 0 stops the transaction of the first connector with one
+func (handler *CentralSystemHandler) SetChargePointRemoteStop(chargePointID string, connectorID int) bool {
+	cp, ok := handler.ChargePoints[chargePointID]
+	if !ok {
+		return false
+	}
+	txid := -1
+	for _, id := range cp.dlmConnectorIDs() {
+		if connector, ok := cp.Connectors[id]; ok && (connectorID == 0 || id == connectorID) && connector.hasTransactionInProgress() {
+			txid = connector.CurrentTransaction
+			break
+		}
+	}
+	if txid < 0 {
+		return false
+	}
 	callback3 := func(confirmation *core.RemoteStopTransactionConfirmation, err error) {
 		log.Println("Confirmation")
 	}
-	txid := handler.ChargePoints[chargePointID].Connectors[1].CurrentTransaction
 	println(txid)
 	_ = centralSystem.RemoteStopTransaction(chargePointID, callback3, txid)
 	return true
@@ -454,14 +518,17 @@ func (handler *CentralSystemHandler) UnlockPort(chargePointID string, ConnID int
 	return
 }
 
-func (handler *CentralSystemHandler) OverridePowerTarget(chargePointID string, limit string) bool {
-	_, exists := handler.ChargePoints[chargePointID]
-	var err error
+// OverridePowerTarget Http-RPC, connector 0 sets the target of all connectors
+func (handler *CentralSystemHandler) OverridePowerTarget(chargePointID string, limit string, connectorID int) bool {
+	cp, exists := handler.ChargePoints[chargePointID]
 	if exists {
-		handler.ChargePoints[chargePointID].CurrentTargeted.L1, err = strconv.Atoi(limit)
-		handler.ChargePoints[chargePointID].CurrentTargeted.L2, err = strconv.Atoi(limit)
-		handler.ChargePoints[chargePointID].CurrentTargeted.L3, err = strconv.Atoi(limit)
+		current, err := strconv.Atoi(limit)
 		if err == nil {
+			for _, id := range cp.dlmConnectorIDs() {
+				if connectorID == 0 || id == connectorID {
+					cp.getConnector(id).setTargeted(current)
+				}
+			}
 			groupid := handler.ChargePoints[chargePointID].DLMGroup
 			handler.Groups[groupid].DLMActionPending = true
 			return true
@@ -487,6 +554,35 @@ func (handler *CentralSystemHandler) chargePointByID(id string) (*ChargePointSta
 	return cp, nil
 }
 
+// sendRequestSync sends a request to a charge point and waits for its confirmation
+func (handler *CentralSystemHandler) sendRequestSync(chargePointID string, request ocpp.Request) (ocpp.Response, error) {
+	type result struct {
+		confirmation ocpp.Response
+		err          error
+	}
+	done := make(chan result, 1)
+	err := centralSystem.SendRequestAsync(chargePointID, request, func(confirmation ocpp.Response, err error) {
+		done <- result{confirmation, err}
+	})
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case r := <-done:
+		return r.confirmation, r.err
+	case <-time.After(confirmationtimeout * time.Second):
+		return nil, fmt.Errorf("no confirmation for %v within %vs", request.GetFeatureName(), confirmationtimeout)
+	}
+}
+
+// remoteStopTransaction asks a charger to stop a transaction, errors are only logged
+func (handler *CentralSystemHandler) remoteStopTransaction(chargePointID string, transactionID int) {
+	_, err := handler.sendRequestSync(chargePointID, core.NewRemoteStopTransactionRequest(transactionID))
+	if err != nil {
+		logDefault(chargePointID, core.RemoteStopTransactionFeatureName).Errorf("couldn't stop transaction %v: %v", transactionID, err)
+	}
+}
+
 func (handler *CentralSystemHandler) SetConfig(id string, key string, value string) bool {
 	var success = false
 	log.Println(key)
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+// IdentityEntry Http-RPC reply, one card or Autocharge MAC
+type IdentityEntry struct {
+	IdTag string `json:"id_tag"`
+	authIdStruct
+}
+
+// splitIdTag tells Autocharge MACs ("MAC" prefix) from cards, key is the identity map key
+func splitIdTag(idTag string) (key string, isMac bool) {
+	if strings.HasPrefix(idTag, "MAC") {
+		return strings.TrimPrefix(idTag, "MAC"), true
+	}
+	return idTag, false
+}
+
+func identityMap(isMac bool) map[string]authIdStruct {
+	if isMac {
+		if identity.MACs == nil {
+			identity.MACs = map[string]authIdStruct{}
+		}
+		return identity.MACs
+	}
+	if identity.Cards == nil {
+		identity.Cards = map[string]authIdStruct{}
+	}
+	return identity.Cards
+}
+
+// lookupIdentity returns the identity of an id tag as seen by the charger
+func lookupIdentity(idTag string) (authIdStruct, bool) {
+	key, isMac := splitIdTag(idTag)
+	auth, exists := identityMap(isMac)[key]
+	return auth, exists
+}
+
+// storeIdentity writes an identity back into its map
+func storeIdentity(idTag string, auth authIdStruct) {
+	key, isMac := splitIdTag(idTag)
+	identityMap(isMac)[key] = auth
+}
+
+func saveIdentityFile() error {
+	authlistjson, err := json.MarshalIndent(identity, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(authlistfilename, authlistjson, 0644)
+}
+
+// commitIdentity persists identity and pushes the change to the chargers' local lists
+func (handler *CentralSystemHandler) commitIdentity() error {
+	handler.identityChanged()
+	if err := saveIdentityFile(); err != nil {
+		log.Printf("couldn't save %v: %v", authlistfilename, err)
+		return err
+	}
+	return nil
+}
+
+// IdentityDetails are the optional fields of an identity, given as RPC params label, owner, expiry (RFC3339) and parent id tag
+type IdentityDetails struct {
+	Label       string
+	Owner       string
+	ExpiryDate  *types.DateTime
+	ParentIdTag string
+}
+
+func parseIdentityDetails(params []string) (IdentityDetails, error) {
+	var details IdentityDetails
+	var err error
+	if len(params) > 0 {
+		details.Label = params[0]
+	}
+	if len(params) > 1 {
+		details.Owner = params[1]
+	}
+	if len(params) > 2 && params[2] != "" {
+		details.ExpiryDate, err = parseDateTimeParam(params[2])
+	}
+	if len(params) > 3 {
+		details.ParentIdTag = params[3]
+	}
+	return details, err
+}
+
+func (details IdentityDetails) apply(idTag string, auth *authIdStruct) error {
+	if details.ParentIdTag != "" {
+		if details.ParentIdTag == idTag {
+			return fmt.Errorf("%v can't be its own parent", idTag)
+		}
+		if _, exists := lookupIdentity(details.ParentIdTag); !exists {
+			return fmt.Errorf("unknown parent id tag %v", details.ParentIdTag)
+		}
+	}
+	auth.Label = details.Label
+	auth.Owner = details.Owner
+	auth.ExpiryDate = details.ExpiryDate
+	auth.ParentIdTag = details.ParentIdTag
+	return nil
+}
+
+// AddIdTag Http-RPC, MACs are added with their "MAC" prefix
+func (handler *CentralSystemHandler) AddIdTag(idTag string, details IdentityDetails) (*IdentityEntry, error) {
+	if idTag == "" || idTag == "MAC" || len(idTag) > 20 {
+		return nil, fmt.Errorf("id tag must be 1 to 20 characters")
+	}
+	if _, exists := lookupIdentity(idTag); exists {
+		return nil, fmt.Errorf("id tag %v already exists", idTag)
+	}
+	auth := authIdStruct{TXList: map[string]TransactionInfo{}, Authorized: true}
+	if err := details.apply(idTag, &auth); err != nil {
+		return nil, err
+	}
+	storeIdentity(idTag, auth)
+	log.Printf("id tag %v added", idTag)
+	return &IdentityEntry{IdTag: idTag, authIdStruct: auth}, handler.commitIdentity()
+}
+
+// UpdateIdTag Http-RPC, replaces label, owner, expiry and parent of an id tag
+func (handler *CentralSystemHandler) UpdateIdTag(idTag string, details IdentityDetails) (*IdentityEntry, error) {
+	auth, exists := lookupIdentity(idTag)
+	if !exists {
+		return nil, fmt.Errorf("unknown id tag %v", idTag)
+	}
+	if err := details.apply(idTag, &auth); err != nil {
+		return nil, err
+	}
+	storeIdentity(idTag, auth)
+	log.Printf("id tag %v updated", idTag)
+	return &IdentityEntry{IdTag: idTag, authIdStruct: auth}, handler.commitIdentity()
+}
+
+// SetIdTagAuthorized Http-RPC, blocks or unblocks an id tag
+func (handler *CentralSystemHandler) SetIdTagAuthorized(idTag string, authorized bool) error {
+	auth, exists := lookupIdentity(idTag)
+	if !exists {
+		return fmt.Errorf("unknown id tag %v", idTag)
+	}
+	auth.Authorized = authorized
+	storeIdentity(idTag, auth)
+	log.Printf("id tag %v authorized: %v", idTag, authorized)
+	return handler.commitIdentity()
+}
+
+// DeleteIdTag Http-RPC, id tags still used as parent can't be deleted
+func (handler *CentralSystemHandler) DeleteIdTag(idTag string) error {
+	if _, exists := lookupIdentity(idTag); !exists {
+		return fmt.Errorf("unknown id tag %v", idTag)
+	}
+	for _, entry := range handler.GetIdTags("") {
+		if entry.ParentIdTag == idTag {
+			return fmt.Errorf("id tag %v is parent of %v", idTag, entry.IdTag)
+		}
+	}
+	key, isMac := splitIdTag(idTag)
+	delete(identityMap(isMac), key)
+	log.Printf("id tag %v deleted", idTag)
+	return handler.commitIdentity()
+}
+
+// GetIdTags Http-RPC, kind is "cards", "macs" or empty for both
+func (handler *CentralSystemHandler) GetIdTags(kind string) []IdentityEntry {
+	list := []IdentityEntry{}
+	if kind == "" || kind == "cards" {
+		for tag, auth := range identity.Cards {
+			list = append(list, IdentityEntry{IdTag: tag, authIdStruct: auth})
+		}
+	}
+	if kind == "" || kind == "macs" {
+		for mac, auth := range identity.MACs {
+			list = append(list, IdentityEntry{IdTag: "MAC" + mac, authIdStruct: auth})
+		}
+	}
+	return list
+}
+
+// authorizeIdTag checks an id tag including expiry date and parent id tag, reason is for logging only
+func (handler *CentralSystemHandler) authorizeIdTag(idTag string) (*types.IdTagInfo, string) {
+	auth, exists := lookupIdentity(idTag)
+	if !exists {
+		return types.NewIdTagInfo(types.AuthorizationStatusInvalid), "not in list"
+	}
+	info := &types.IdTagInfo{Status: types.AuthorizationStatusAccepted, ExpiryDate: auth.ExpiryDate, ParentIdTag: auth.ParentIdTag}
+	if !auth.Authorized {
+		info.Status = types.AuthorizationStatusBlocked
+		return info, "blocked"
+	}
+	if auth.ExpiryDate != nil && auth.ExpiryDate.Before(time.Now()) {
+		info.Status = types.AuthorizationStatusExpired
+		return info, "expired"
+	}
+	if auth.ParentIdTag != "" {
+		parent, exists := lookupIdentity(auth.ParentIdTag)
+		switch {
+		case !exists:
+			info.Status = types.AuthorizationStatusInvalid
+			return info, "parent " + auth.ParentIdTag + " not in list"
+		case !parent.Authorized:
+			info.Status = types.AuthorizationStatusBlocked
+			return info, "parent " + auth.ParentIdTag + " blocked"
+		case parent.ExpiryDate != nil && parent.ExpiryDate.Before(time.Now()):
+			info.Status = types.AuthorizationStatusExpired
+			return info, "parent " + auth.ParentIdTag + " expired"
+		}
+	}
+	if remaining, limited := handler.remainingQuota(idTag); limited && remaining <= 0 {
+		info.Status = types.AuthorizationStatusBlocked
+		return info, "energy quota used up"
+	}
+	return info, "authorized"
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+const (
+	EventIdleGraceExpired = "IdleGraceExpired"
+	EventBayBlocked       = "BayBlocked"
+)
+
+// IdleConnector Http-RPC reply, a connector occupied by a car that is done charging
+type IdleConnector struct {
+	ChargePointID string          `json:"charge_point_id"`
+	ConnectorId   int             `json:"connector_id"`
+	TransactionId int             `json:"transaction_id"`
+	IdTag         string          `json:"id_tag"`
+	IdleSince     *types.DateTime `json:"idle_since"`
+	IdleMinutes   int             `json:"idle_minutes"`
+	GraceMinutes  int             `json:"grace_minutes"`
+	Notified      bool            `json:"notified"`
+	Escalated     bool            `json:"escalated"`
+}
+
+// markIdle records when the car on a connector stopped drawing power, the transaction keeps it for the idle fee
+func (handler *CentralSystemHandler) markIdle(chargePointID string, connector *ConnectorInfo) {
+	if connector.IdleSince != nil || !connector.hasTransactionInProgress() {
+		return
+	}
+	now := types.NewDateTime(time.Now())
+	connector.IdleSince = now
+	connector.IdleNotified = false
+	connector.IdleEscalated = false
+	if transaction, ok := handler.Transactions[connector.CurrentTransaction]; ok && transaction.IdleSince == nil {
+		transaction.IdleSince = now
+	}
+	log.WithField("client", chargePointID).Infof("transaction %v idle, car is done charging", connector.CurrentTransaction)
+}
+
+// clearIdle is called when energy flows again or the connector is freed
+func (handler *CentralSystemHandler) clearIdle(connector *ConnectorInfo) {
+	if connector.IdleSince == nil {
+		return
+	}
+	if transaction, ok := handler.Transactions[connector.CurrentTransaction]; ok && !transaction.hasTransactionEnded() {
+		transaction.IdleSince = nil
+	}
+	connector.IdleSince = nil
+	connector.IdleNotified = false
+	connector.IdleEscalated = false
+}
+
+// idleGraceMinutes is the grace period of the transaction's tariff, idlegraceminutes without a tariff or
+// if the tariff has neither an idle fee nor a grace period
+func (handler *CentralSystemHandler) idleGraceMinutes(transaction *TransactionInfo) int {
+	if _, tariff := handler.tariffOf(transaction); tariff != nil && (tariff.IdleFee > 0 || tariff.IdleGraceMinutes > 0) {
+		return tariff.IdleGraceMinutes
+	}
+	return idlegraceminutes
+}
+
+// GetIdleConnectors Http-RPC
+func (handler *CentralSystemHandler) GetIdleConnectors() []IdleConnector {
+	list := []IdleConnector{}
+	for name, cp := range handler.ChargePoints {
+		for id, connector := range cp.Connectors {
+			transaction, ok := handler.Transactions[connector.CurrentTransaction]
+			if connector.IdleSince == nil || !connector.hasTransactionInProgress() || !ok {
+				continue
+			}
+			list = append(list, IdleConnector{
+				ChargePointID: name,
+				ConnectorId:   id,
+				TransactionId: transaction.Id,
+				IdTag:         transaction.IdTag,
+				IdleSince:     connector.IdleSince,
+				IdleMinutes:   int(time.Since(connector.IdleSince.Time).Minutes()),
+				GraceMinutes:  handler.idleGraceMinutes(transaction),
+				Notified:      connector.IdleNotified,
+				Escalated:     connector.IdleEscalated,
+			})
+		}
+	}
+	return list
+}
+
+func (handler *CentralSystemHandler) idlestart() {
+	ticker := time.NewTicker(idleinterval * time.Second)
+	go func() {
+		for range ticker.C {
+			handler.checkIdleConnectors()
+		}
+	}()
+}
+
+// checkIdleConnectors tells the driver once the grace period is over and the operator if the bay stays blocked
+func (handler *CentralSystemHandler) checkIdleConnectors() {
+	for _, idle := range handler.GetIdleConnectors() {
+		connector := handler.ChargePoints[idle.ChargePointID].Connectors[idle.ConnectorId]
+		event := Event{ChargePointID: idle.ChargePointID, ConnectorId: idle.ConnectorId, TransactionId: idle.TransactionId, IdTag: idle.IdTag}
+		if idle.IdleMinutes >= idle.GraceMinutes && !connector.IdleNotified {
+			connector.IdleNotified = true
+			event.Type = EventIdleGraceExpired
+			event.Audience = EventAudienceDriver
+			event.Message = fmt.Sprintf("charging finished %v minutes ago, please move your car", idle.IdleMinutes)
+			handler.raiseEvent(event)
+		}
+		if idle.IdleMinutes >= idle.GraceMinutes+idleescalateminutes && !connector.IdleEscalated {
+			connector.IdleEscalated = true
+			event.Type = EventBayBlocked
+			event.Audience = EventAudienceOperator
+			event.Message = fmt.Sprintf("connector %v blocked by an idle car for %v minutes", idle.ConnectorId, idle.IdleMinutes)
+			handler.raiseEvent(event)
+		}
+	}
+}
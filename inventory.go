@@ -0,0 +1,151 @@
+package main
+
+import (
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/core"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+// Keys that differ between chargers by design and are left out of the drift view
+var driftIgnoredKeys = map[string]bool{
+	"AuthorizationKey":      true,
+	"ChargeBoxSerialNumber": true,
+	"ChargePointId":         true,
+	"CentralSystemURL":      true,
+}
+
+// BootInfo contains the charge point data sent with the last BootNotification
+type BootInfo struct {
+	Vendor                  string          `json:"vendor"`
+	Model                   string          `json:"model"`
+	ChargePointSerialNumber string          `json:"charge_point_serial_number"`
+	ChargeBoxSerialNumber   string          `json:"charge_box_serial_number"`
+	FirmwareVersion         string          `json:"firmware_version"`
+	Iccid                   string          `json:"iccid"`
+	Imsi                    string          `json:"imsi"`
+	MeterType               string          `json:"meter_type"`
+	MeterSerialNumber       string          `json:"meter_serial_number"`
+	Time                    *types.DateTime `json:"time"`
+}
+
+// ConfigurationValue is one key of a GetConfiguration dump, Value is nil if the charger didn't report one
+type ConfigurationValue struct {
+	Value    *string `json:"value"`
+	Readonly bool    `json:"readonly"`
+}
+
+// InventoryEntry Http-RPC reply for a single charge point
+type InventoryEntry struct {
+	Status               core.ChargePointStatus `json:"status"`
+	Boot                 BootInfo               `json:"boot"`
+	ConfigurationKeys    int                    `json:"configuration_keys"`
+	ConfigurationUpdated *types.DateTime        `json:"configuration_updated"`
+}
+
+func newBootInfo(request *core.BootNotificationRequest) BootInfo {
+	return BootInfo{
+		Vendor:                  request.ChargePointVendor,
+		Model:                   request.ChargePointModel,
+		ChargePointSerialNumber: request.ChargePointSerialNumber,
+		ChargeBoxSerialNumber:   request.ChargeBoxSerialNumber,
+		FirmwareVersion:         request.FirmwareVersion,
+		Iccid:                   request.Iccid,
+		Imsi:                    request.Imsi,
+		MeterType:               request.MeterType,
+		MeterSerialNumber:       request.MeterSerialNumber,
+		Time:                    types.NewDateTime(time.Now()),
+	}
+}
+
+// RefreshConfiguration takes a full GetConfiguration snapshot of a charge point
+func (handler *CentralSystemHandler) RefreshConfiguration(chargePointID string) error {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return err
+	}
+	handler.configMutex.Lock()
+	defer handler.configMutex.Unlock()
+	response, err := handler.sendRequestSync(chargePointID, core.NewGetConfigurationRequest(nil))
+	if err != nil {
+		logDefault(chargePointID, core.GetConfigurationFeatureName).Errorf("error on request: %v", err)
+		return err
+	}
+	confirmation := response.(*core.GetConfigurationConfirmation)
+	configuration := make(map[string]ConfigurationValue, len(confirmation.ConfigurationKey))
+	for _, key := range confirmation.ConfigurationKey {
+		configuration[key.Key] = ConfigurationValue{Value: key.Value, Readonly: key.Readonly}
+	}
+	cp.Configuration = configuration
+	cp.ConfigurationUpdated = types.NewDateTime(time.Now())
+	logDefault(chargePointID, confirmation.GetFeatureName()).Infof("configuration snapshot with %v keys", len(configuration))
+	return nil
+}
+
+// GetInventory Http-RPC
+func (handler *CentralSystemHandler) GetInventory() map[string]InventoryEntry {
+	inventory := make(map[string]InventoryEntry, len(handler.ChargePoints))
+	for name, cp := range handler.ChargePoints {
+		inventory[name] = InventoryEntry{Status: cp.Status, Boot: cp.Boot, ConfigurationKeys: len(cp.Configuration), ConfigurationUpdated: cp.ConfigurationUpdated}
+	}
+	return inventory
+}
+
+// GetConfigurationSnapshot Http-RPC, the last snapshot of a charge point
+func (handler *CentralSystemHandler) GetConfigurationSnapshot(chargePointID string) (map[string]ConfigurationValue, error) {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return nil, err
+	}
+	return cp.Configuration, nil
+}
+
+// GetConfigurationDrift Http-RPC, returns per model every key whose value differs between chargers of that model
+// (model -> key -> charge point -> value). An empty model compares all models.
+func (handler *CentralSystemHandler) GetConfigurationDrift(model string) map[string]map[string]map[string]string {
+	byModel := make(map[string][]string)
+	for name, cp := range handler.ChargePoints {
+		if cp.Configuration == nil || (model != "" && cp.Boot.Model != model) {
+			continue
+		}
+		byModel[cp.Boot.Model] = append(byModel[cp.Boot.Model], name)
+	}
+	drift := make(map[string]map[string]map[string]string)
+	for modelName, chargers := range byModel {
+		if len(chargers) < 2 {
+			continue
+		}
+		keys := make(map[string]bool)
+		for _, name := range chargers {
+			for key := range handler.ChargePoints[name].Configuration {
+				keys[key] = true
+			}
+		}
+		modelDrift := make(map[string]map[string]string)
+		for key := range keys {
+			if driftIgnoredKeys[key] {
+				continue
+			}
+			values := make(map[string]string, len(chargers))
+			distinct := make(map[string]bool)
+			for _, name := range chargers {
+				value := "<missing>"
+				if entry, ok := handler.ChargePoints[name].Configuration[key]; ok {
+					value = "<unset>"
+					if entry.Value != nil {
+						value = *entry.Value
+					}
+				}
+				values[name] = value
+				distinct[value] = true
+			}
+			if len(distinct) > 1 {
+				modelDrift[key] = values
+			}
+		}
+		if len(modelDrift) > 0 {
+			drift[modelName] = modelDrift
+		}
+	}
+	return drift
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// startSession marks a started transaction as the current session of its identity
+func startSession(transaction *TransactionInfo) {
+	auth, exists := lookupIdentity(transaction.IdTag)
+	if !exists {
+		return
+	}
+	auth.CurrentSession = int64(transaction.Id)
+	storeIdentity(transaction.IdTag, auth)
+}
+
+// bookTransaction books a finished transaction onto the identity that started it,
+// the stop id tag only matters for the log since the session belongs to the start tag
+func bookTransaction(transaction *TransactionInfo) {
+	auth, exists := lookupIdentity(transaction.IdTag)
+	if !exists {
+		log.WithField("client", transaction.ChargePointID).Infof("transaction %v of %v not booked, no local id tag", transaction.Id, transaction.IdTag)
+		return
+	}
+	key := strconv.Itoa(transaction.Id)
+	if auth.TXList == nil {
+		auth.TXList = map[string]TransactionInfo{}
+	}
+	// a meter stop below the meter start is flagged and books nothing
+	energy := transaction.meteredEnergy()
+	if previous, booked := auth.TXList[key]; booked {
+		// a repeated StopTransaction replaces the booking instead of counting it twice
+		auth.EnergyCharged -= previous.meteredEnergy()
+	}
+	auth.TXList[key] = *transaction
+	auth.EnergyCharged += energy
+	if auth.CurrentSession == int64(transaction.Id) {
+		auth.CurrentSession = 0
+	}
+	storeIdentity(transaction.IdTag, auth)
+	log.WithField("client", transaction.ChargePointID).Infof("booked %v Wh of transaction %v onto %v, %v Wh in total", energy, transaction.Id, transaction.IdTag, auth.EnergyCharged)
+	if err := saveIdentityFile(); err != nil {
+		log.Printf("couldn't save %v: %v", authlistfilename, err)
+	}
+}
+
+// GetTransactions Http-RPC, the booked transactions of an id tag started within [from, to), nil bounds are open
+func (handler *CentralSystemHandler) GetTransactions(idTag string, from time.Time, to time.Time) ([]TransactionInfo, error) {
+	auth, exists := lookupIdentity(idTag)
+	if !exists {
+		return nil, fmt.Errorf("unknown id tag %v", idTag)
+	}
+	list := []TransactionInfo{}
+	for _, transaction := range auth.TXList {
+		if transaction.StartTime == nil {
+			continue
+		}
+		if !from.IsZero() && transaction.StartTime.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !transaction.StartTime.Before(to) {
+			continue
+		}
+		list = append(list, transaction)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartTime.Before(list[j].StartTime.Time)
+	})
+	return list, nil
+}
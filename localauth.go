@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/localauth"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+// LocalListState is the local authorization list version a charger has, as far as we know
+type LocalListState struct {
+	Version         int             `json:"version"`
+	ReportedVersion int             `json:"reported_version"`
+	Status          string          `json:"status"`
+	Mismatch        bool            `json:"mismatch"`
+	Synced          *types.DateTime `json:"synced"`
+}
+
+// localListEntry is what a charger gets to know about an identity for offline authorization
+func localListEntry(auth authIdStruct) types.IdTagInfo {
+	info := types.IdTagInfo{Status: types.AuthorizationStatusBlocked, ExpiryDate: auth.ExpiryDate, ParentIdTag: auth.ParentIdTag}
+	if auth.Authorized {
+		info.Status = types.AuthorizationStatusAccepted
+	}
+	return info
+}
+
+// buildLocalList creates the local authorization list from identity, MACs are sent with their "MAC" prefix
+func buildLocalList() map[string]types.IdTagInfo {
+	list := make(map[string]types.IdTagInfo, len(identity.Cards)+len(identity.MACs))
+	for tag, auth := range identity.Cards {
+		list[tag] = localListEntry(auth)
+	}
+	for mac, auth := range identity.MACs {
+		list["MAC"+mac] = localListEntry(auth)
+	}
+	return list
+}
+
+// identityChanged publishes a new local list version and sends the differences to all connected chargers
+func (handler *CentralSystemHandler) identityChanged() {
+	list := buildLocalList()
+	var changes []localauth.AuthorizationData
+	for tag, info := range list {
+		if old, ok := handler.LocalList[tag]; !ok || !reflect.DeepEqual(old, info) {
+			entry := info
+			changes = append(changes, localauth.AuthorizationData{IdTag: tag, IdTagInfo: &entry})
+		}
+	}
+	for tag := range handler.LocalList {
+		if _, ok := list[tag]; !ok {
+			// no IdTagInfo removes the entry from the charger's list
+			changes = append(changes, localauth.AuthorizationData{IdTag: tag})
+		}
+	}
+	if len(changes) == 0 && handler.LocalListVersion > 0 {
+		return
+	}
+	handler.LocalList = list
+	handler.LocalListVersion++
+	log.Printf("local authorization list version %v with %v changes", handler.LocalListVersion, len(changes))
+	chargers := make([]string, 0, len(handler.setupStarted))
+	for name := range handler.setupStarted {
+		chargers = append(chargers, name)
+	}
+	// the changes belong to this version, the goroutines may run after the next change was published
+	version := handler.LocalListVersion
+	for _, name := range chargers {
+		go func(name string) {
+			cp, ok := handler.ChargePoints[name]
+			if ok && cp.LocalList.Version == version-1 && !cp.LocalList.Mismatch {
+				_ = handler.sendLocalList(name, version, localauth.UpdateTypeDifferential, changes)
+			} else {
+				_ = handler.SyncLocalList(name)
+			}
+		}(name)
+	}
+}
+
+// SyncLocalList Http-RPC, sends the full list unless the charger already reports the current version
+func (handler *CentralSystemHandler) SyncLocalList(chargePointID string) error {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return err
+	}
+	reported, err := handler.getLocalListVersion(chargePointID)
+	if err == nil && reported == handler.LocalListVersion && cp.LocalList.Version == handler.LocalListVersion {
+		cp.LocalList.Mismatch = false
+		return nil
+	}
+	version := handler.LocalListVersion
+	list := make([]localauth.AuthorizationData, 0, len(handler.LocalList))
+	for tag, info := range handler.LocalList {
+		entry := info
+		list = append(list, localauth.AuthorizationData{IdTag: tag, IdTagInfo: &entry})
+	}
+	return handler.sendLocalList(chargePointID, version, localauth.UpdateTypeFull, list)
+}
+
+// sendLocalList sends a list as the given version, which must be the version the list was built for
+func (handler *CentralSystemHandler) sendLocalList(chargePointID string, version int, updateType localauth.UpdateType, list []localauth.AuthorizationData) error {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return err
+	}
+	request := localauth.NewSendLocalListRequest(version, updateType)
+	request.LocalAuthorizationList = list
+	response, err := handler.sendRequestSync(chargePointID, request)
+	if err != nil {
+		logDefault(chargePointID, request.GetFeatureName()).Errorf("error on request: %v", err)
+		cp.LocalList.Status = "Error"
+		return err
+	}
+	status := response.(*localauth.SendLocalListConfirmation).Status
+	cp.LocalList.Status = string(status)
+	logDefault(chargePointID, request.GetFeatureName()).Infof("%v update to version %v with %v entries: %v", updateType, version, len(list), status)
+	switch status {
+	case localauth.UpdateStatusAccepted:
+		cp.LocalList.Version = version
+		cp.LocalList.Synced = types.NewDateTime(time.Now())
+	case localauth.UpdateStatusVersionMismatch:
+		cp.LocalList.Mismatch = true
+		if updateType == localauth.UpdateTypeDifferential {
+			return handler.SyncLocalList(chargePointID)
+		}
+		return fmt.Errorf("charger reported %v on full update", status)
+	default:
+		return fmt.Errorf("charger reported %v", status)
+	}
+	reported, err := handler.getLocalListVersion(chargePointID)
+	if err != nil {
+		return err
+	}
+	cp.LocalList.Mismatch = reported != version
+	if cp.LocalList.Mismatch {
+		logDefault(chargePointID, localauth.GetLocalListVersionFeatureName).Warnf("charger reports local list version %v, expected %v", reported, version)
+	}
+	return nil
+}
+
+func (handler *CentralSystemHandler) getLocalListVersion(chargePointID string) (int, error) {
+	response, err := handler.sendRequestSync(chargePointID, localauth.NewGetLocalListVersionRequest())
+	if err != nil {
+		logDefault(chargePointID, localauth.GetLocalListVersionFeatureName).Errorf("error on request: %v", err)
+		return 0, err
+	}
+	version := response.(*localauth.GetLocalListVersionConfirmation).ListVersion
+	if cp, ok := handler.ChargePoints[chargePointID]; ok {
+		cp.LocalList.ReportedVersion = version
+	}
+	return version, nil
+}
+
+// GetLocalListStatus Http-RPC
+func (handler *CentralSystemHandler) GetLocalListStatus() map[string]interface{} {
+	chargers := make(map[string]LocalListState, len(handler.ChargePoints))
+	for name, cp := range handler.ChargePoints {
+		chargers[name] = cp.LocalList
+	}
+	return map[string]interface{}{"version": handler.LocalListVersion, "entries": len(handler.LocalList), "charge_points": chargers}
+}
+
+// ReloadIdentities Http-RPC, re-reads the identity file after it was edited by hand
+func (handler *CentralSystemHandler) ReloadIdentities() error {
+	authFile, err := ioutil.ReadFile(authlistfilename)
+	if err != nil {
+		return err
+	}
+	var reloaded ident
+	if err = json.Unmarshal(authFile, &reloaded); err != nil {
+		return err
+	}
+	identity = reloaded
+	handler.identityChanged()
+	return nil
+}
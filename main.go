@@ -4,14 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"strconv"
 	"time"
 
 	ocpp16 "github.com/lorenzodonini/ocpp-go/ocpp1.6"
 	"github.com/lorenzodonini/ocpp-go/ocpp1.6/core"
 	"github.com/lorenzodonini/ocpp-go/ocpp1.6/firmware"
-	"github.com/lorenzodonini/ocpp-go/ocpp1.6/localauth"
 	"github.com/lorenzodonini/ocpp-go/ocpp1.6/remotetrigger"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
 	"github.com/lorenzodonini/ocpp-go/ocppj"
 	"github.com/sirupsen/logrus"
 )
@@ -31,6 +30,37 @@ const (
 	dlmrampdownafterunusedcurrentfor = 60
 	timetostandbyvehicle             = 60
 	rampdowntocurrentoffset          = 1
+	chargepointpasswordbytes         = 16
+	pendingbootretryinterval         = 30
+	confirmationtimeout              = 10
+	firmwaredir                      = "firmware"
+	diagnosticsdir                   = "diagnostics"
+	maxdiagnosticsbytes              = 100 << 20
+	exportsdir                       = "exports"
+	meterdatadir                     = "meterdata"
+	ocpipagelimit                    = 50
+	ocpicommandtimeout               = 30
+	ocpicountrycode                  = "DE"
+	maxevents                        = 1000
+	idleinterval                     = 30
+	idlegraceminutes                 = 15
+	idleescalateminutes              = 30
+	dlmmaxcurrent                    = 16
+	dlmlowprioritysoc                = 80
+	dlmlowprioritycurrent            = 8
+	plausibilitycurrentexcess        = 4
+	maxanomalies                     = 100
+	plausibilityrebaselinereadings   = 3
+	dlmchargingprofileid             = 1000
+	ocpipartyid                      = "JCM"
+	campaigninterval                 = 10
+	campaigntargettimeout            = 60
+	reservationinterval              = 30
+	maxpairingminutes                = 30
+	stopunauthorizedafter            = 30
+	quotainterval                    = 30
+	quotareducebelowwh               = 1000
+	quotareducecurrent               = 6
 )
 
 var log *logrus.Logger
@@ -47,58 +77,31 @@ type authIdStruct struct {
 	Authorized     bool                       `json:"authorized"`
 	EnergyCharged  int64                      `json:"energy_charged"`
 	CurrentSession int64                      `json:"current_session"`
+	Label          string                     `json:"label"`
+	Owner          string                     `json:"owner"`
+	ExpiryDate     *types.DateTime            `json:"expiry_date"`
+	ParentIdTag    string                     `json:"parent_id_tag"`
+	QuotaWh        int64                      `json:"quota_wh"`
+	QuotaPeriod    string                     `json:"quota_period"`
+	Tariff         string                     `json:"tariff"`
+	TargetSoC      int                        `json:"target_soc"`
 }
 
-func setupCentralSystem() ocpp16.CentralSystem {
-	return ocpp16.NewCentralSystem(nil, nil)
+func setupCentralSystem(handler *CentralSystemHandler) ocpp16.CentralSystem {
+	return ocpp16.NewCentralSystem(nil, setupWebsocketServer(handler))
 }
 
 // Run for every connected Charge Point, pushing config
 func setupRoutine(chargePointID string, handler *CentralSystemHandler) {
 	var e error
-	var KeyMeterValuesSampledData = "MeterValuesSampledData"
-	var KeyMeterValueSampleInterval = "MeterValueSampleInterval"
-	var MeterSampleInterval = "10"
 
 	//Wait
 	time.Sleep(waitinterval * time.Second)
-	// Change meter sampling values time
-	callback1 := func(confirmation *core.ChangeConfigurationConfirmation, err error) {
-		if err != nil {
-			logDefault(chargePointID, core.ChangeConfigurationFeatureName).Errorf("error on request: %v", err)
-		} else if confirmation.Status == core.ConfigurationStatusNotSupported {
-			logDefault(chargePointID, confirmation.GetFeatureName()).Warnf("couldn't update configuration for unsupported key: %v", KeyMeterValueSampleInterval)
-		} else if confirmation.Status == core.ConfigurationStatusRejected {
-			logDefault(chargePointID, confirmation.GetFeatureName()).Warnf("couldn't update configuration for readonly key: %v", KeyMeterValueSampleInterval)
-		} else {
-			logDefault(chargePointID, confirmation.GetFeatureName()).Infof("updated configuration for key %v to: %v", KeyMeterValueSampleInterval, MeterSampleInterval)
-		}
-	}
-	e = centralSystem.ChangeConfiguration(chargePointID, callback1, KeyMeterValueSampleInterval, MeterSampleInterval)
-	if e != nil {
-		logDefault(chargePointID, localauth.GetLocalListVersionFeatureName).Errorf("couldn't send message: %v", e)
-		return
-	}
-
-	// Setting Meter Sampling Data (Supported by JuiceMe, maximum data)
-	const ValuePreferedMeterValuesSampleData = "Current.Import.L1,Current.Import.L2,Current.Import.L3,Current.Offered,Energy.Active.Import.Register,Power.Active.Import"
-	time.Sleep(waitinterval * time.Second)
-	callback1v2 := func(confirmation *core.ChangeConfigurationConfirmation, err error) {
-		if err != nil {
-			logDefault(chargePointID, core.ChangeConfigurationFeatureName).Errorf("error on request: %v", err)
-		} else if confirmation.Status == core.ConfigurationStatusNotSupported {
-			logDefault(chargePointID, confirmation.GetFeatureName()).Warnf("couldn't update configuration for unsupported key: %v", KeyMeterValueSampleInterval)
-		} else if confirmation.Status == core.ConfigurationStatusRejected {
-			logDefault(chargePointID, confirmation.GetFeatureName()).Warnf("couldn't update configuration for readonly key: %v", KeyMeterValueSampleInterval)
-		} else {
-			logDefault(chargePointID, confirmation.GetFeatureName()).Infof("updated configuration for key %v to: %v", KeyMeterValueSampleInterval, MeterSampleInterval)
-		}
-	}
-	e = centralSystem.ChangeConfiguration(chargePointID, callback1v2, KeyMeterValuesSampledData, ValuePreferedMeterValuesSampleData)
-	if e != nil {
-		logDefault(chargePointID, localauth.GetLocalListVersionFeatureName).Errorf("couldn't send message: %v", e)
-		return
-	}
+	// Configuration snapshot for the inventory, then push the assigned config profiles
+	_ = handler.RefreshConfiguration(chargePointID)
+	_ = handler.ReconcileConfiguration(chargePointID)
+	// Local authorization list for offline authorization
+	_ = handler.SyncLocalList(chargePointID)
 
 	//set all value 0 for Power
 	cp := handler.ChargePoints[chargePointID]
@@ -109,7 +112,10 @@ func setupRoutine(chargePointID string, handler *CentralSystemHandler) {
 	cp.Currents.L1 = 0
 	cp.Currents.L2 = 0
 	cp.Currents.L3 = 0
-	cp.MaxingPowerForDLMCycles = 0
+	for _, connector := range cp.Connectors {
+		connector.Currents = PortCurrents{}
+		connector.MaxingPowerForDLMCycles = 0
+	}
 	//done, all Load values reset
 
 	// Wait
@@ -149,30 +155,17 @@ func setupRoutine(chargePointID string, handler *CentralSystemHandler) {
 	}
 	//Start Set to safe Charge Limit, so in case something breaks whilst dlm its doing its stuff we don't trip a breaker, lulz
 	time.Sleep(waitinterval * time.Second)
-	if !handler.ChargePointsInitialized[chargePointID] {
-		success := handler.SetConfig(chargePointID, "DlmOperatorPhase1Limit", "0")
-		if success {
-			success = handler.SetConfig(chargePointID, "DlmOperatorPhase2Limit", "0")
-		}
-		if success {
-			success = handler.SetConfig(chargePointID, "DlmOperatorPhase3Limit", "0")
+	initialized := handler.ChargePointsInitialized[chargePointID]
+	for _, connectorID := range cp.dlmConnectorIDs() {
+		limit := PortCurrents{}
+		if initialized {
+			limit = cp.getConnector(connectorID).CurrentTargeted
 		}
-		if !success {
-			log.Println("Error whilst setting safe current!!!!!!!!!!!!!!!!!!!!!") //maybe something here to stop autorization on that guy until its manually solved
-		}
-		handler.ChargePointsInitialized[chargePointID] = true
-	} else {
-		success := handler.SetConfig(chargePointID, "DlmOperatorPhase1Limit", strconv.Itoa(handler.ChargePoints[chargePointID].CurrentTargeted.L1))
-		if success {
-			success = handler.SetConfig(chargePointID, "DlmOperatorPhase2Limit", strconv.Itoa(handler.ChargePoints[chargePointID].CurrentTargeted.L2))
-		}
-		if success {
-			success = handler.SetConfig(chargePointID, "DlmOperatorPhase3Limit", strconv.Itoa(handler.ChargePoints[chargePointID].CurrentTargeted.L3))
-		}
-		if !success {
+		if !handler.applyConnectorLimit(chargePointID, connectorID, limit) {
 			log.Println("Error whilst setting safe current!!!!!!!!!!!!!!!!!!!!!") //maybe something here to stop autorization on that guy until its manually solved
 		}
 	}
+	handler.ChargePointsInitialized[chargePointID] = true
 
 	///End Set to safe Charge Limit
 
@@ -184,15 +177,21 @@ func main() {
 	authFile, _ := ioutil.ReadFile(authlistfilename)
 	_ = json.Unmarshal(authFile, &identity)
 	//persistence for centralSystem
-	handler := &CentralSystemHandler{ChargePoints: map[string]*ChargePointState{}, Groups: map[string]*Group{}, GroupsInitialized: map[string]bool{}, ChargePointsInitialized: map[string]bool{}, debug: debugvalue, Transactions: map[int]*TransactionInfo{}}
+	handler := &CentralSystemHandler{ChargePoints: map[string]*ChargePointState{}, Groups: map[string]*Group{}, GroupsInitialized: map[string]bool{}, ChargePointsInitialized: map[string]bool{}, debug: debugvalue, Transactions: map[int]*TransactionInfo{}, Credentials: map[string]*ChargePointCredential{}, Registrations: map[string]*ChargerRegistration{}, setupStarted: map[string]bool{}, ConfigProfiles: map[string]*ConfigProfile{}, Campaigns: map[int]*FirmwareCampaign{}, Reservations: map[int]*Reservation{}, Pairings: map[string]*PairingSession{}, Tariffs: map[string]*Tariff{}, CDRs: map[int]*CDR{}, OCPI: OCPIConfig{CountryCode: ocpicountrycode, PartyID: ocpipartyid, Partners: map[string]*OCPIPartner{}, Tokens: map[string]*OCPIToken{}, Locations: map[string]*OCPILocationInfo{}}}
 
 	//Leave commented out for now until we have a file
 	centralSystemFile, _ := ioutil.ReadFile(centralsystemfilename)
 	_ = json.Unmarshal(centralSystemFile, &handler)
+	handler.migrateLegacyDLMState(centralSystemFile)
+	if _, ok := handler.ConfigProfiles[defaultConfigProfile]; !ok {
+		handler.ConfigProfiles[defaultConfigProfile] = newDefaultConfigProfile()
+	}
+	// Publishes a new local list version if ident.json was changed while we were down
+	handler.identityChanged()
 	// Load config from const
 	var listenPort = defaultListenPort
 	// Prepare OCPP 1.6 central system
-	centralSystem = setupCentralSystem()
+	centralSystem = setupCentralSystem(handler)
 	// Support callbacks for all OCPP 1.6 profiles
 	centralSystem.SetCoreHandler(handler)
 	centralSystem.SetLocalAuthListHandler(handler)
@@ -216,13 +215,17 @@ func main() {
 			handler.Groups[groupdid].Chargers[chargePoint.ID()] = "true"
 		}
 		handler.ChargePoints[chargePoint.ID()].DLMGroup = groupdid
-		go setupRoutine(chargePoint.ID(), handler)
+		//Pending charge points get their setup after being accepted on BootNotification
+		if handler.isRegistrationAccepted(chargePoint.ID()) {
+			handler.startSetupRoutine(chargePoint.ID())
+		}
 	})
 	//DisconnectHandler
 	centralSystem.SetChargePointDisconnectedHandler(func(chargePoint ocpp16.ChargePointConnection) {
 		log.WithField("client", chargePoint.ID()).Info("charge point disconnected")
 		//delete(handler.chargePoints, chargePoint.ID())
 		handler.ChargePoints[chargePoint.ID()].Status = core.ChargePointStatusUnavailable
+		delete(handler.setupStarted, chargePoint.ID())
 		groupdid := string([]rune(chargePoint.ID())[0])
 		delete(handler.Groups[groupdid].Chargers, chargePoint.ID())
 	})
@@ -233,6 +236,10 @@ func main() {
 	log.Infof("starting central system on port %v", listenPort)
 	go handler.Listen(version)
 	go handler.dlmstart()
+	go handler.campaignstart()
+	go handler.reservationstart()
+	go handler.quotastart()
+	go handler.idlestart()
 	centralSystem.Start(listenPort, "/{ws}")
 	log.Info("stopped central system")
 	defer func() {
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// measurandUnits are the OCPP 1.6 measurands and the unit values are normalised to, empty for unitless ones
+var measurandUnits = map[string]string{
+	"Current.Export":                  "A",
+	"Current.Import":                  "A",
+	"Current.Offered":                 "A",
+	"Energy.Active.Export.Register":   "Wh",
+	"Energy.Active.Import.Register":   "Wh",
+	"Energy.Reactive.Export.Register": "varh",
+	"Energy.Reactive.Import.Register": "varh",
+	"Energy.Active.Export.Interval":   "Wh",
+	"Energy.Active.Import.Interval":   "Wh",
+	"Energy.Reactive.Export.Interval": "varh",
+	"Energy.Reactive.Import.Interval": "varh",
+	"Frequency":                       "",
+	"Power.Active.Export":             "W",
+	"Power.Active.Import":             "W",
+	"Power.Factor":                    "",
+	"Power.Offered":                   "W",
+	"Power.Reactive.Export":           "var",
+	"Power.Reactive.Import":           "var",
+	"RPM":                             "",
+	"SoC":                             "Percent",
+	"Temperature":                     "Celsius",
+	"Voltage":                         "V",
+}
+
+type unitConversion struct {
+	base   string
+	factor float64
+	offset float64
+}
+
+// unitConversions convert the OCPP 1.6 units into their base unit as value*factor+offset
+var unitConversions = map[string]unitConversion{
+	"Wh":         {"Wh", 1, 0},
+	"kWh":        {"Wh", 1000, 0},
+	"varh":       {"varh", 1, 0},
+	"kvarh":      {"varh", 1000, 0},
+	"W":          {"W", 1, 0},
+	"kW":         {"W", 1000, 0},
+	"VA":         {"VA", 1, 0},
+	"kVA":        {"VA", 1000, 0},
+	"var":        {"var", 1, 0},
+	"kvar":       {"var", 1000, 0},
+	"A":          {"A", 1, 0},
+	"V":          {"V", 1, 0},
+	"Celsius":    {"Celsius", 1, 0},
+	"K":          {"Celsius", 1, -273.15},
+	"Fahrenheit": {"Celsius", 5.0 / 9.0, -32 * 5.0 / 9.0},
+	"Percent":    {"Percent", 1, 0},
+}
+
+// MeterReading is a sampled value converted to the base unit of its measurand, signed values carry no number
+type MeterReading struct {
+	Measurand string  `json:"measurand"`
+	Phase     string  `json:"phase,omitempty"`
+	Unit      string  `json:"unit"`
+	Value     float64 `json:"value"`
+	Signed    bool    `json:"signed"`
+}
+
+// parseMeterValue parses a sampled value of a MeterValues or StopTransaction request. An empty measurand
+// is the energy register as defined by OCPP, an empty unit is the base unit of the measurand.
+func parseMeterValue(measurand string, phase string, unit string, format string, value string) (MeterReading, error) {
+	if measurand == "" {
+		measurand = measurandEnergyImport
+	}
+	base, known := measurandUnits[measurand]
+	if !known {
+		return MeterReading{}, fmt.Errorf("unknown measurand %q", measurand)
+	}
+	reading := MeterReading{Measurand: measurand, Phase: phase, Unit: base}
+	switch format {
+	case "", "Raw":
+	case "SignedData":
+		reading.Signed = true
+		return reading, nil
+	default:
+		return reading, fmt.Errorf("unknown format %q of %v", format, measurand)
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return reading, fmt.Errorf("invalid value %q of %v", value, measurand)
+	}
+	if unit != "" && base != "" {
+		conversion, ok := unitConversions[unit]
+		if !ok || conversion.base != base {
+			return reading, fmt.Errorf("unit %q doesn't fit %v", unit, measurand)
+		}
+		number = number*conversion.factor + conversion.offset
+		if math.IsInf(number, 0) {
+			return reading, fmt.Errorf("value %q of %v out of range", value, measurand)
+		}
+	} else if base == "" {
+		reading.Unit = unit
+	}
+	if measurand == "SoC" && (number < 0 || number > 100) {
+		return reading, fmt.Errorf("SoC %v out of range", number)
+	}
+	reading.Value = number
+	return reading, nil
+}
+
+// phaseOf maps "L1" and "L1-N" to "L1", line to line and neutral phases to ""
+func phaseOf(phase string) string {
+	switch phase {
+	case "L1", "L1-N":
+		return "L1"
+	case "L2", "L2-N":
+		return "L2"
+	case "L3", "L3-N":
+		return "L3"
+	}
+	return ""
+}
+
+// applyMeterReading updates the latest values of a charger, the integer DLM values are rounded
+func (cp *ChargePointState) applyMeterReading(reading MeterReading) {
+	key := reading.Measurand
+	if reading.Phase != "" {
+		key += "." + reading.Phase
+	}
+	if cp.MeterReadings == nil {
+		cp.MeterReadings = map[string]float64{}
+	}
+	cp.MeterReadings[key] = reading.Value
+	rounded := int(math.Round(reading.Value))
+	phase := phaseOf(reading.Phase)
+	switch reading.Measurand {
+	case "Power.Active.Import":
+		switch phase {
+		case "L1":
+			cp.Power.L1 = rounded
+		case "L2":
+			cp.Power.L2 = rounded
+		case "L3":
+			cp.Power.L3 = rounded
+		default:
+			if reading.Phase == "" {
+				cp.Power.Total = rounded
+			}
+		}
+	case "Current.Offered":
+		cp.CurrentOffered = rounded
+	case "Current.Import":
+		switch phase {
+		case "L1":
+			cp.Currents.L1 = rounded
+		case "L2":
+			cp.Currents.L2 = rounded
+		case "L3":
+			cp.Currents.L3 = rounded
+		default:
+			log.Printf("Unexpected meterValue phase %q for %v", reading.Phase, reading.Measurand)
+		}
+	case "Energy.Active.Import.Register":
+		if reading.Phase == "" {
+			cp.EnergyMeterCurrent = int64(math.Round(reading.Value))
+		}
+	case "Energy.Active.Export.Register":
+		cp.EnergyExportRegister = reading.Value
+	case "Voltage":
+		switch phase {
+		case "L1":
+			cp.Voltage.L1 = reading.Value
+		case "L2":
+			cp.Voltage.L2 = reading.Value
+		case "L3":
+			cp.Voltage.L3 = reading.Value
+		}
+	case "Temperature":
+		cp.Temperature = reading.Value
+	case "SoC":
+		cp.SoC = reading.Value
+	case "Power.Offered":
+		cp.PowerOffered = reading.Value
+	}
+}
+
+// meterConnector is the connector meter values of a connector id belong to, values of connector 0 belong
+// to the only connector of a single connector charger, nil for the main meter of others
+func (cp *ChargePointState) meterConnector(connectorID int) *ConnectorInfo {
+	if connectorID > 0 {
+		return cp.getConnector(connectorID)
+	}
+	if ids := cp.dlmConnectorIDs(); len(ids) == 1 {
+		return cp.getConnector(ids[0])
+	}
+	return nil
+}
+
+// applyMeterReading updates the values of a connector the DLM works with
+func (ci *ConnectorInfo) applyMeterReading(reading MeterReading) {
+	rounded := int(math.Round(reading.Value))
+	switch reading.Measurand {
+	case "Current.Offered":
+		ci.CurrentOffered = rounded
+	case "Current.Import":
+		switch phaseOf(reading.Phase) {
+		case "L1":
+			ci.Currents.L1 = rounded
+		case "L2":
+			ci.Currents.L2 = rounded
+		case "L3":
+			ci.Currents.L3 = rounded
+		}
+	case "Energy.Active.Import.Register":
+		if reading.Phase == "" {
+			ci.EnergyMeterCurrent = int64(math.Round(reading.Value))
+		}
+	}
+}
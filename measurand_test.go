@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseMeterValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		measurand string
+		phase     string
+		unit      string
+		format    string
+		value     string
+		want      float64
+		wantUnit  string
+		signed    bool
+		wantErr   bool
+	}{
+		{name: "kW to W", measurand: "Power.Active.Import", unit: "kW", value: "11", want: 11000, wantUnit: "W"},
+		{name: "kWh to Wh", measurand: "Energy.Active.Import.Register", unit: "kWh", value: "1.5", want: 1500, wantUnit: "Wh"},
+		{name: "empty measurand is energy register", value: "1234", want: 1234, wantUnit: "Wh"},
+		{name: "empty unit is base unit", measurand: "Current.Import", phase: "L1", value: "16", want: 16, wantUnit: "A"},
+		{name: "Kelvin to Celsius", measurand: "Temperature", unit: "K", value: "300.15", want: 27, wantUnit: "Celsius"},
+		{name: "Fahrenheit to Celsius", measurand: "Temperature", unit: "Fahrenheit", value: "212", want: 100, wantUnit: "Celsius"},
+		{name: "decimal value", measurand: "Voltage", unit: "V", value: " 230.45 ", want: 230.45, wantUnit: "V"},
+		{name: "raw format", measurand: "SoC", unit: "Percent", format: "Raw", value: "80", want: 80, wantUnit: "Percent"},
+		{name: "signed data", measurand: "Energy.Active.Import.Register", format: "SignedData", value: "OCMF|{}|{}", wantUnit: "Wh", signed: true},
+		{name: "unknown format", measurand: "Voltage", format: "Hex", value: "1", wantErr: true},
+		{name: "unknown measurand", measurand: "Humidity", value: "1", wantErr: true},
+		{name: "unknown unit", measurand: "Voltage", unit: "mV", value: "1", wantErr: true},
+		{name: "unit of other measurand", measurand: "Current.Import", unit: "W", value: "1", wantErr: true},
+		{name: "not a number", measurand: "Voltage", value: "abc", wantErr: true},
+		{name: "NaN", measurand: "Voltage", value: "NaN", wantErr: true},
+		{name: "Inf", measurand: "Voltage", value: "+Inf", wantErr: true},
+		{name: "overflow after conversion", measurand: "Energy.Active.Import.Register", unit: "kWh", value: "1e308", wantErr: true},
+		{name: "SoC above 100", measurand: "SoC", value: "101", wantErr: true},
+		{name: "SoC below 0", measurand: "SoC", value: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reading, err := parseMeterValue(tt.measurand, tt.phase, tt.unit, tt.format, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", reading)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if reading.Signed != tt.signed || reading.Unit != tt.wantUnit || math.Abs(reading.Value-tt.want) > 1e-9 {
+				t.Fatalf("got %+v, want %v %v signed=%v", reading, tt.want, tt.wantUnit, tt.signed)
+			}
+		})
+	}
+}
+
+func FuzzParseMeterValue(f *testing.F) {
+	f.Add("Energy.Active.Import.Register", "", "kWh", "", "12.5")
+	f.Add("Temperature", "", "Fahrenheit", "Raw", "-40")
+	f.Add("Current.Import", "L1-N", "A", "", "16")
+	f.Add("SoC", "", "Percent", "", "NaN")
+	f.Add("", "", "", "SignedData", "OCMF|")
+	f.Fuzz(func(t *testing.T, measurand string, phase string, unit string, format string, value string) {
+		reading, err := parseMeterValue(measurand, phase, unit, format, value)
+		if err == nil && (math.IsNaN(reading.Value) || math.IsInf(reading.Value, 0)) {
+			t.Fatalf("parseMeterValue(%q, %q, %q, %q, %q) returned %v", measurand, phase, unit, format, value, reading.Value)
+		}
+	})
+}
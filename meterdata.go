@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+const (
+	measurandEnergyImport = "Energy.Active.Import.Register"
+	measurandPowerImport  = "Power.Active.Import"
+	measurandCurrent      = "Current.Import"
+)
+
+// MeterSample is one sampled value as sent by the charger, stored one json line per sample
+type MeterSample struct {
+	Timestamp     time.Time `json:"timestamp"`
+	TransactionId int       `json:"transaction_id"`
+	ConnectorId   int       `json:"connector_id"`
+	Measurand     string    `json:"measurand"`
+	Phase         string    `json:"phase,omitempty"`
+	Unit          string    `json:"unit,omitempty"`
+	Context       string    `json:"context,omitempty"`
+	Location      string    `json:"location,omitempty"`
+	Format        string    `json:"format,omitempty"`
+	Value         string    `json:"value"`
+}
+
+// CurvePoint is the charging state at one point in time of a session curve
+type CurvePoint struct {
+	Time     time.Time  `json:"time"`
+	PowerW   float64    `json:"power_w"`
+	CurrentA PhaseCurve `json:"current_a"`
+	EnergyWh float64    `json:"energy_wh"`
+	SoC      float64    `json:"soc,omitempty"`
+}
+
+// PhaseCurve are the currents of a curve point per phase
+type PhaseCurve struct {
+	L1 float64 `json:"l1"`
+	L2 float64 `json:"l2"`
+	L3 float64 `json:"l3"`
+}
+
+// SessionCurve Http-RPC reply
+type SessionCurve struct {
+	TransactionId int          `json:"transaction_id"`
+	ChargePointID string       `json:"charge_point_id"`
+	Samples       int          `json:"samples"`
+	Points        []CurvePoint `json:"points"`
+}
+
+// meterDataFile is meterdata/<charger>/<transaction>.jsonl, values outside a transaction go to a file per day
+func meterDataFile(chargePointID string, transactionID int, timestamp time.Time) (string, error) {
+	dir, err := cleanFileName(chargePointID)
+	if err != nil {
+		return "", err
+	}
+	name := strconv.Itoa(transactionID) + ".jsonl"
+	if transactionID < 0 {
+		name = "charger-" + timestamp.Format("2006-01-02") + ".jsonl"
+	}
+	return filepath.Join(meterdatadir, dir, name), nil
+}
+
+// storeMeterValues appends all sampled values to the time series of their transaction
+func (handler *CentralSystemHandler) storeMeterValues(chargePointID string, connectorID int, transactionID int, meterValues []types.MeterValue) {
+	files := map[string][]MeterSample{}
+	for _, mv := range meterValues {
+		timestamp := time.Now()
+		if mv.Timestamp != nil {
+			timestamp = mv.Timestamp.Time
+		}
+		file, err := meterDataFile(chargePointID, transactionID, timestamp)
+		if err != nil {
+			log.WithField("client", chargePointID).Errorf("couldn't store meter values: %v", err)
+			return
+		}
+		for _, sv := range mv.SampledValue {
+			measurand := string(sv.Measurand)
+			if measurand == "" {
+				measurand = measurandEnergyImport
+			}
+			files[file] = append(files[file], MeterSample{
+				Timestamp:     timestamp,
+				TransactionId: transactionID,
+				ConnectorId:   connectorID,
+				Measurand:     measurand,
+				Phase:         string(sv.Phase),
+				Unit:          string(sv.Unit),
+				Context:       string(sv.Context),
+				Location:      string(sv.Location),
+				Format:        string(sv.Format),
+				Value:         sv.Value,
+			})
+		}
+	}
+	for file, samples := range files {
+		if err := appendSamples(file, samples); err != nil {
+			log.WithField("client", chargePointID).Errorf("couldn't store meter values in %v: %v", file, err)
+		}
+	}
+}
+
+func appendSamples(file string, samples []MeterSample) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	for _, sample := range samples {
+		if err = encoder.Encode(sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSamples reads the stored time series of a transaction
+func readSamples(chargePointID string, transactionID int) ([]MeterSample, error) {
+	file, err := meterDataFile(chargePointID, transactionID, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	samples := []MeterSample{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var sample MeterSample
+		if err = json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			return nil, fmt.Errorf("corrupt line in %v: %v", file, err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+// sampleNumber returns a sample in the base unit of its measurand
+func sampleNumber(sample MeterSample) (float64, bool) {
+	reading, err := parseMeterValue(sample.Measurand, sample.Phase, sample.Unit, sample.Format, sample.Value)
+	if err != nil || reading.Signed {
+		return 0, false
+	}
+	return reading.Value, true
+}
+
+// sessionPoints merges the samples taken at the same time into curve points, power without a total is
+// summed up from the phases. Points without energy register or SoC keep the last known value.
+func sessionPoints(samples []MeterSample) []CurvePoint {
+	type accumulator struct {
+		point       CurvePoint
+		phasePower  float64
+		hasTotal    bool
+		hasMeasured bool
+		hasEnergy   bool
+		hasSoC      bool
+	}
+	byTime := map[time.Time]*accumulator{}
+	for _, sample := range samples {
+		value, ok := sampleNumber(sample)
+		if !ok || (sample.Location != "" && sample.Location != "Outlet") {
+			continue
+		}
+		acc, exists := byTime[sample.Timestamp]
+		if !exists {
+			acc = &accumulator{point: CurvePoint{Time: sample.Timestamp}}
+			byTime[sample.Timestamp] = acc
+		}
+		switch sample.Measurand {
+		case measurandPowerImport:
+			acc.hasMeasured = true
+			if sample.Phase == "" {
+				acc.point.PowerW = value
+				acc.hasTotal = true
+			} else {
+				acc.phasePower += value
+			}
+		case measurandCurrent:
+			acc.hasMeasured = true
+			switch phaseOf(sample.Phase) {
+			case "L1":
+				acc.point.CurrentA.L1 = value
+			case "L2":
+				acc.point.CurrentA.L2 = value
+			case "L3":
+				acc.point.CurrentA.L3 = value
+			}
+		case measurandEnergyImport:
+			if sample.Phase == "" {
+				acc.hasMeasured = true
+				acc.hasEnergy = true
+				acc.point.EnergyWh = value
+			}
+		case "SoC":
+			acc.hasMeasured = true
+			acc.hasSoC = true
+			acc.point.SoC = value
+		}
+	}
+	measured := []*accumulator{}
+	for _, acc := range byTime {
+		if acc.hasMeasured {
+			measured = append(measured, acc)
+		}
+	}
+	sort.Slice(measured, func(i, j int) bool {
+		return measured[i].point.Time.Before(measured[j].point.Time)
+	})
+	points := make([]CurvePoint, 0, len(measured))
+	var energy, soc float64
+	for _, acc := range measured {
+		if !acc.hasTotal {
+			acc.point.PowerW = acc.phasePower
+		}
+		if acc.hasEnergy {
+			energy = acc.point.EnergyWh
+		}
+		if acc.hasSoC {
+			soc = acc.point.SoC
+		}
+		acc.point.EnergyWh, acc.point.SoC = energy, soc
+		points = append(points, acc.point)
+	}
+	return points
+}
+
+// downsample averages consecutive points into at most maxPoints buckets, energy register and SoC keep their last value
+func downsample(points []CurvePoint, maxPoints int) []CurvePoint {
+	if maxPoints <= 0 || len(points) <= maxPoints {
+		return points
+	}
+	size := (len(points) + maxPoints - 1) / maxPoints
+	result := make([]CurvePoint, 0, maxPoints)
+	for start := 0; start < len(points); start += size {
+		end := start + size
+		if end > len(points) {
+			end = len(points)
+		}
+		bucket := points[start:end]
+		point := CurvePoint{Time: bucket[0].Time, EnergyWh: bucket[len(bucket)-1].EnergyWh, SoC: bucket[len(bucket)-1].SoC}
+		for _, p := range bucket {
+			point.PowerW += p.PowerW / float64(len(bucket))
+			point.CurrentA.L1 += p.CurrentA.L1 / float64(len(bucket))
+			point.CurrentA.L2 += p.CurrentA.L2 / float64(len(bucket))
+			point.CurrentA.L3 += p.CurrentA.L3 / float64(len(bucket))
+		}
+		result = append(result, point)
+	}
+	return result
+}
+
+// GetSessionCurve Http-RPC, power, current and energy of a transaction with at most maxPoints points (0 for all)
+func (handler *CentralSystemHandler) GetSessionCurve(transactionID int, maxPoints int) (*SessionCurve, error) {
+	transaction, ok := handler.Transactions[transactionID]
+	if !ok {
+		return nil, fmt.Errorf("unknown transaction %v", transactionID)
+	}
+	samples, err := readSamples(transaction.ChargePointID, transactionID)
+	if os.IsNotExist(err) {
+		samples, err = []MeterSample{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SessionCurve{
+		TransactionId: transactionID,
+		ChargePointID: transaction.ChargePointID,
+		Samples:       len(samples),
+		Points:        downsample(sessionPoints(samples), maxPoints),
+	}, nil
+}
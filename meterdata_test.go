@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionCurveKeepsEnergyRegister(t *testing.T) {
+	start := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	at := func(minute int) time.Time { return start.Add(time.Duration(minute) * time.Minute) }
+	samples := []MeterSample{
+		{Timestamp: at(0), Measurand: "Energy.Active.Import.Register", Value: "1000"},
+		{Timestamp: at(0), Measurand: "Power.Active.Import", Value: "11000"},
+		{Timestamp: at(1), Measurand: "Power.Active.Import", Value: "11000"},
+		{Timestamp: at(2), Measurand: "Energy.Active.Import.Register", Value: "1400"},
+		{Timestamp: at(2), Measurand: "Energy.Active.Import.Register", Phase: "L1", Value: "300"},
+		{Timestamp: at(3), Measurand: "Power.Active.Import", Value: "7000"},
+	}
+	points := sessionPoints(samples)
+	want := []float64{1000, 1000, 1400, 1400}
+	if len(points) != len(want) {
+		t.Fatalf("got %v points, want %v", len(points), len(want))
+	}
+	for i, point := range points {
+		if point.EnergyWh != want[i] {
+			t.Fatalf("point %v has %v Wh, want %v Wh", i, point.EnergyWh, want[i])
+		}
+	}
+	// the last point of both buckets carries no energy sample of its own
+	for i, point := range downsample(points, 2) {
+		if point.EnergyWh != want[2*i+1] {
+			t.Fatalf("bucket %v has %v Wh, want %v Wh", i, point.EnergyWh, want[2*i+1])
+		}
+	}
+}
@@ -0,0 +1,965 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/core"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+// OCPI status codes
+const (
+	ocpiSuccess            = 1000
+	ocpiClientError        = 2000
+	ocpiInvalidParameters  = 2001
+	ocpiUnknownLocation    = 2003
+	ocpiUnknownToken       = 2004
+	ocpiServerError        = 3000
+	ocpiTimeFormat         = "2006-01-02T15:04:05Z"
+	ocpiEVSESeparator      = "-"
+	ocpiDefaultMaxAmperage = 16
+)
+
+// OCPIConfig is our CPO identity and everything exchanged with eMSP partners
+type OCPIConfig struct {
+	CountryCode string                       `json:"country_code"`
+	PartyID     string                       `json:"party_id"`
+	BaseURL     string                       `json:"base_url"`
+	Partners    map[string]*OCPIPartner      `json:"partners"`
+	Tokens      map[string]*OCPIToken        `json:"tokens"`
+	Locations   map[string]*OCPILocationInfo `json:"locations"`
+}
+
+// OCPIPartner is an eMSP, Token is what it sends to us, CallbackToken what we send to it. URL is the base
+// URL of the partner's endpoints, command results are only sent below it.
+type OCPIPartner struct {
+	Name          string `json:"name"`
+	CountryCode   string `json:"country_code"`
+	PartyID       string `json:"party_id"`
+	URL           string `json:"url"`
+	Token         string `json:"token"`
+	CallbackToken string `json:"callback_token"`
+}
+
+// OCPIToken is a foreign token pushed by an eMSP, its UID is the id tag the charger sees
+type OCPIToken struct {
+	CountryCode  string `json:"country_code"`
+	PartyID      string `json:"party_id"`
+	UID          string `json:"uid"`
+	Type         string `json:"type"`
+	ContractID   string `json:"contract_id"`
+	VisualNumber string `json:"visual_number,omitempty"`
+	Issuer       string `json:"issuer"`
+	Valid        bool   `json:"valid"`
+	Whitelist    string `json:"whitelist"`
+	LastUpdated  string `json:"last_updated"`
+	Partner      string `json:"partner,omitempty"`
+	// remoteStartOn is the charger the partner sent START_SESSION for, the online authorization of NEVER tokens
+	remoteStartOn string
+}
+
+// OCPILocationInfo is what OCPI needs to know about a charger's site, only chargers with it are published
+type OCPILocationInfo struct {
+	Name        string `json:"name"`
+	Address     string `json:"address"`
+	City        string `json:"city"`
+	PostalCode  string `json:"postal_code"`
+	Country     string `json:"country"`
+	Latitude    string `json:"latitude"`
+	Longitude   string `json:"longitude"`
+	TimeZone    string `json:"time_zone"`
+	MaxAmperage int    `json:"max_amperage"`
+}
+
+type ocpiResponse struct {
+	Data          interface{} `json:"data,omitempty"`
+	StatusCode    int         `json:"status_code"`
+	StatusMessage string      `json:"status_message,omitempty"`
+	Timestamp     string      `json:"timestamp"`
+}
+
+type ocpiGeoLocation struct {
+	Latitude  string `json:"latitude"`
+	Longitude string `json:"longitude"`
+}
+
+type ocpiConnector struct {
+	ID          string `json:"id"`
+	Standard    string `json:"standard"`
+	Format      string `json:"format"`
+	PowerType   string `json:"power_type"`
+	MaxVoltage  int    `json:"max_voltage"`
+	MaxAmperage int    `json:"max_amperage"`
+	LastUpdated string `json:"last_updated"`
+}
+
+type ocpiEVSE struct {
+	UID         string          `json:"uid"`
+	Status      string          `json:"status"`
+	Connectors  []ocpiConnector `json:"connectors"`
+	LastUpdated string          `json:"last_updated"`
+}
+
+type ocpiLocation struct {
+	CountryCode string          `json:"country_code"`
+	PartyID     string          `json:"party_id"`
+	ID          string          `json:"id"`
+	Publish     bool            `json:"publish"`
+	Name        string          `json:"name,omitempty"`
+	Address     string          `json:"address"`
+	City        string          `json:"city"`
+	PostalCode  string          `json:"postal_code,omitempty"`
+	Country     string          `json:"country"`
+	Coordinates ocpiGeoLocation `json:"coordinates"`
+	EVSEs       []ocpiEVSE      `json:"evses"`
+	TimeZone    string          `json:"time_zone"`
+	LastUpdated string          `json:"last_updated"`
+}
+
+type ocpiCdrToken struct {
+	CountryCode string `json:"country_code"`
+	PartyID     string `json:"party_id"`
+	UID         string `json:"uid"`
+	Type        string `json:"type"`
+	ContractID  string `json:"contract_id"`
+}
+
+type ocpiPrice struct {
+	ExclVat float64 `json:"excl_vat"`
+}
+
+type ocpiSession struct {
+	CountryCode   string       `json:"country_code"`
+	PartyID       string       `json:"party_id"`
+	ID            string       `json:"id"`
+	StartDateTime string       `json:"start_date_time"`
+	EndDateTime   string       `json:"end_date_time,omitempty"`
+	KWh           float64      `json:"kwh"`
+	CdrToken      ocpiCdrToken `json:"cdr_token"`
+	AuthMethod    string       `json:"auth_method"`
+	LocationID    string       `json:"location_id"`
+	EvseUID       string       `json:"evse_uid"`
+	ConnectorID   string       `json:"connector_id"`
+	Currency      string       `json:"currency"`
+	TotalCost     *ocpiPrice   `json:"total_cost,omitempty"`
+	Status        string       `json:"status"`
+	LastUpdated   string       `json:"last_updated"`
+}
+
+type ocpiCdrDimension struct {
+	Type   string  `json:"type"`
+	Volume float64 `json:"volume"`
+}
+
+type ocpiChargingPeriod struct {
+	StartDateTime string             `json:"start_date_time"`
+	Dimensions    []ocpiCdrDimension `json:"dimensions"`
+}
+
+type ocpiCdrLocation struct {
+	ID                 string          `json:"id"`
+	Name               string          `json:"name,omitempty"`
+	Address            string          `json:"address"`
+	City               string          `json:"city"`
+	PostalCode         string          `json:"postal_code,omitempty"`
+	Country            string          `json:"country"`
+	Coordinates        ocpiGeoLocation `json:"coordinates"`
+	EvseUID            string          `json:"evse_uid"`
+	EvseID             string          `json:"evse_id"`
+	ConnectorID        string          `json:"connector_id"`
+	ConnectorStandard  string          `json:"connector_standard"`
+	ConnectorFormat    string          `json:"connector_format"`
+	ConnectorPowerType string          `json:"connector_power_type"`
+}
+
+type ocpiCDR struct {
+	CountryCode      string               `json:"country_code"`
+	PartyID          string               `json:"party_id"`
+	ID               string               `json:"id"`
+	StartDateTime    string               `json:"start_date_time"`
+	EndDateTime      string               `json:"end_date_time"`
+	CdrToken         ocpiCdrToken         `json:"cdr_token"`
+	AuthMethod       string               `json:"auth_method"`
+	CdrLocation      ocpiCdrLocation      `json:"cdr_location"`
+	Currency         string               `json:"currency"`
+	ChargingPeriods  []ocpiChargingPeriod `json:"charging_periods"`
+	TotalCost        ocpiPrice            `json:"total_cost"`
+	TotalEnergy      float64              `json:"total_energy"`
+	TotalTime        float64              `json:"total_time"`
+	TotalParkingTime float64              `json:"total_parking_time,omitempty"`
+	LastUpdated      string               `json:"last_updated"`
+}
+
+type ocpiCommandRequest struct {
+	ResponseURL            string     `json:"response_url"`
+	Token                  *OCPIToken `json:"token"`
+	LocationID             string     `json:"location_id"`
+	EvseUID                string     `json:"evse_uid"`
+	ConnectorID            string     `json:"connector_id"`
+	SessionID              string     `json:"session_id"`
+	AuthorizationReference string     `json:"authorization_reference"`
+}
+
+type ocpiCommandResponse struct {
+	Result  string `json:"result"`
+	Timeout int    `json:"timeout"`
+}
+
+type ocpiCommandResult struct {
+	Result  string `json:"result"`
+	Message string `json:"message,omitempty"`
+}
+
+func ocpiTime(t time.Time) string {
+	return t.UTC().Format(ocpiTimeFormat)
+}
+
+// ocpiBaseURL is the public URL of the /ocpi endpoints, the file server URL is the fallback
+func (handler *CentralSystemHandler) ocpiBaseURL() (string, error) {
+	if handler.OCPI.BaseURL != "" {
+		return strings.TrimRight(handler.OCPI.BaseURL, "/"), nil
+	}
+	url, err := handler.requireFileServerURL()
+	if err != nil {
+		return "", fmt.Errorf("OCPI base URL not configured, use setOCPIParty or setFileServerURL")
+	}
+	return url + "/ocpi", nil
+}
+
+func ocpiReply(w http.ResponseWriter, httpStatus int, statusCode int, message string, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(httpStatus)
+	_ = json.NewEncoder(w).Encode(ocpiResponse{Data: data, StatusCode: statusCode, StatusMessage: message, Timestamp: ocpiTime(time.Now())})
+}
+
+// ocpiTokenHeader encodes a credentials token the way OCPI 2.2 sends it
+func ocpiTokenHeader(token string) string {
+	return "Token " + base64.StdEncoding.EncodeToString([]byte(token))
+}
+
+// ocpiPartnerOf finds the partner by its token, plain or base64 encoded (older OCPI clients don't encode)
+func (handler *CentralSystemHandler) ocpiPartnerOf(r *http.Request) *OCPIPartner {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Token ") {
+		return nil
+	}
+	candidates := []string{strings.TrimPrefix(header, "Token ")}
+	if decoded, err := base64.StdEncoding.DecodeString(candidates[0]); err == nil {
+		candidates = append(candidates, string(decoded))
+	}
+	for _, partner := range handler.OCPI.Partners {
+		for _, token := range candidates {
+			if partner.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(partner.Token)) == 1 {
+				return partner
+			}
+		}
+	}
+	return nil
+}
+
+type ocpiHandlerFunc func(w http.ResponseWriter, r *http.Request, partner *OCPIPartner)
+
+func (handler *CentralSystemHandler) ocpiAuth(next ocpiHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		partner := handler.ocpiPartnerOf(r)
+		if partner == nil {
+			log.Printf("OCPI request from %v with unknown token", r.RemoteAddr)
+			ocpiReply(w, http.StatusUnauthorized, ocpiClientError, "unknown token", nil)
+			return
+		}
+		next(w, r, partner)
+	}
+}
+
+// ocpiRoutes registers the CPO endpoints on the API server
+func (handler *CentralSystemHandler) ocpiRoutes(r *mux.Router) {
+	r.HandleFunc("/versions", handler.ocpiAuth(handler.ocpiVersions)).Methods("GET")
+	r.HandleFunc("/2.2", handler.ocpiAuth(handler.ocpiVersionDetails)).Methods("GET")
+	r.HandleFunc("/2.2/locations", handler.ocpiAuth(handler.ocpiLocations)).Methods("GET")
+	r.HandleFunc("/2.2/locations/{location_id}", handler.ocpiAuth(handler.ocpiLocationObject)).Methods("GET")
+	r.HandleFunc("/2.2/locations/{location_id}/{evse_uid}", handler.ocpiAuth(handler.ocpiLocationObject)).Methods("GET")
+	r.HandleFunc("/2.2/locations/{location_id}/{evse_uid}/{connector_id}", handler.ocpiAuth(handler.ocpiLocationObject)).Methods("GET")
+	r.HandleFunc("/2.2/sessions", handler.ocpiAuth(handler.ocpiSessions)).Methods("GET")
+	r.HandleFunc("/2.2/cdrs", handler.ocpiAuth(handler.ocpiCDRs)).Methods("GET")
+	r.HandleFunc("/2.2/tokens/{country_code}/{party_id}/{token_uid}", handler.ocpiAuth(handler.ocpiTokens)).Methods("GET", "PUT", "PATCH")
+	r.HandleFunc("/2.2/commands/{command}", handler.ocpiAuth(handler.ocpiCommands)).Methods("POST")
+	r.HandleFunc("/mock/callback/{command}", handler.ocpiMockCallback).Methods("POST")
+}
+
+func (handler *CentralSystemHandler) ocpiVersions(w http.ResponseWriter, r *http.Request, partner *OCPIPartner) {
+	baseURL, err := handler.ocpiBaseURL()
+	if err != nil {
+		ocpiReply(w, http.StatusInternalServerError, ocpiServerError, err.Error(), nil)
+		return
+	}
+	ocpiReply(w, http.StatusOK, ocpiSuccess, "", []map[string]string{{"version": "2.2", "url": baseURL + "/2.2"}})
+}
+
+func (handler *CentralSystemHandler) ocpiVersionDetails(w http.ResponseWriter, r *http.Request, partner *OCPIPartner) {
+	baseURL, err := handler.ocpiBaseURL()
+	if err != nil {
+		ocpiReply(w, http.StatusInternalServerError, ocpiServerError, err.Error(), nil)
+		return
+	}
+	endpoints := []map[string]string{}
+	for _, endpoint := range [][2]string{{"locations", "SENDER"}, {"sessions", "SENDER"}, {"cdrs", "SENDER"}, {"tokens", "RECEIVER"}, {"commands", "RECEIVER"}} {
+		endpoints = append(endpoints, map[string]string{"identifier": endpoint[0], "role": endpoint[1], "url": baseURL + "/2.2/" + endpoint[0]})
+	}
+	ocpiReply(w, http.StatusOK, ocpiSuccess, "", map[string]interface{}{"version": "2.2", "endpoints": endpoints})
+}
+
+// ocpiPage applies offset and limit and sets the OCPI pagination headers
+func ocpiPage(w http.ResponseWriter, r *http.Request, total int) (int, int) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > ocpipagelimit {
+		limit = ocpipagelimit
+	}
+	if offset < 0 || offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("X-Limit", strconv.Itoa(limit))
+	if end < total {
+		next := *r.URL
+		query := next.Query()
+		query.Set("offset", strconv.Itoa(end))
+		query.Set("limit", strconv.Itoa(limit))
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", "<"+strings.TrimSuffix(requestBaseURL(r), "/")+next.RequestURI()+">; rel=\"next\"")
+	}
+	return offset, end
+}
+
+// requestBaseURL is the scheme and host the request was sent to
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// ocpiDateFilter reads date_from and date_to, missing ones are left open
+func ocpiDateFilter(r *http.Request) (time.Time, time.Time, error) {
+	var bounds [2]time.Time
+	for i, name := range []string{"date_from", "date_to"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return bounds[0], bounds[1], fmt.Errorf("invalid %v %v", name, value)
+		}
+		bounds[i] = t
+	}
+	return bounds[0], bounds[1], nil
+}
+
+func inDateRange(t time.Time, from time.Time, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+// ocpiEVSEStatus maps the OCPP connector status to the OCPI EVSE status
+func ocpiEVSEStatus(status core.ChargePointStatus) string {
+	switch status {
+	case core.ChargePointStatusAvailable:
+		return "AVAILABLE"
+	case core.ChargePointStatusPreparing, core.ChargePointStatusCharging, core.ChargePointStatusSuspendedEV, core.ChargePointStatusSuspendedEVSE, core.ChargePointStatusFinishing:
+		return "CHARGING"
+	case core.ChargePointStatusReserved:
+		return "RESERVED"
+	case core.ChargePointStatusUnavailable:
+		return "INOPERATIVE"
+	case core.ChargePointStatusFaulted:
+		return "OUTOFORDER"
+	}
+	return "UNKNOWN"
+}
+
+func ocpiEVSEUID(chargePointID string, connectorID int) string {
+	return chargePointID + ocpiEVSESeparator + strconv.Itoa(connectorID)
+}
+
+// ocpiConnectorOf resolves an EVSE uid of a location to its connector id
+func ocpiConnectorOf(locationID string, evseUID string) (int, error) {
+	if !strings.HasPrefix(evseUID, locationID+ocpiEVSESeparator) {
+		return 0, fmt.Errorf("unknown evse %v", evseUID)
+	}
+	connectorID, err := strconv.Atoi(strings.TrimPrefix(evseUID, locationID+ocpiEVSESeparator))
+	if err != nil || connectorID < 1 {
+		return 0, fmt.Errorf("unknown evse %v", evseUID)
+	}
+	return connectorID, nil
+}
+
+func (info *OCPILocationInfo) connector(connectorID int, lastUpdated string) ocpiConnector {
+	amperage := info.MaxAmperage
+	if amperage == 0 {
+		amperage = ocpiDefaultMaxAmperage
+	}
+	return ocpiConnector{ID: strconv.Itoa(connectorID), Standard: "IEC_62196_T2", Format: "SOCKET", PowerType: "AC_3_PHASE", MaxVoltage: 230, MaxAmperage: amperage, LastUpdated: lastUpdated}
+}
+
+// ocpiLocationOf builds the location of a published charger, one EVSE per connector
+func (handler *CentralSystemHandler) ocpiLocationOf(chargePointID string) (*ocpiLocation, bool) {
+	info, published := handler.OCPI.Locations[chargePointID]
+	cp, ok := handler.ChargePoints[chargePointID]
+	if !published || !ok {
+		return nil, false
+	}
+	now := ocpiTime(time.Now())
+	location := &ocpiLocation{
+		CountryCode: handler.OCPI.CountryCode,
+		PartyID:     handler.OCPI.PartyID,
+		ID:          chargePointID,
+		Publish:     true,
+		Name:        info.Name,
+		Address:     info.Address,
+		City:        info.City,
+		PostalCode:  info.PostalCode,
+		Country:     info.Country,
+		Coordinates: ocpiGeoLocation{Latitude: info.Latitude, Longitude: info.Longitude},
+		EVSEs:       []ocpiEVSE{},
+		TimeZone:    info.TimeZone,
+		LastUpdated: now,
+	}
+	connectorIDs := []int{}
+	for id := range cp.Connectors {
+		if id > 0 {
+			connectorIDs = append(connectorIDs, id)
+		}
+	}
+	sort.Ints(connectorIDs)
+	for _, id := range connectorIDs {
+		location.EVSEs = append(location.EVSEs, ocpiEVSE{
+			UID:         ocpiEVSEUID(chargePointID, id),
+			Status:      ocpiEVSEStatus(cp.Connectors[id].Status),
+			Connectors:  []ocpiConnector{info.connector(id, now)},
+			LastUpdated: now,
+		})
+	}
+	return location, true
+}
+
+func (handler *CentralSystemHandler) ocpiLocations(w http.ResponseWriter, r *http.Request, partner *OCPIPartner) {
+	ids := make([]string, 0, len(handler.OCPI.Locations))
+	for id := range handler.OCPI.Locations {
+		if _, ok := handler.ChargePoints[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	offset, end := ocpiPage(w, r, len(ids))
+	locations := []*ocpiLocation{}
+	for _, id := range ids[offset:end] {
+		location, _ := handler.ocpiLocationOf(id)
+		locations = append(locations, location)
+	}
+	ocpiReply(w, http.StatusOK, ocpiSuccess, "", locations)
+}
+
+func (handler *CentralSystemHandler) ocpiLocationObject(w http.ResponseWriter, r *http.Request, partner *OCPIPartner) {
+	vars := mux.Vars(r)
+	location, ok := handler.ocpiLocationOf(vars["location_id"])
+	if !ok {
+		ocpiReply(w, http.StatusNotFound, ocpiUnknownLocation, "unknown location", nil)
+		return
+	}
+	evseUID, ok := vars["evse_uid"]
+	if !ok {
+		ocpiReply(w, http.StatusOK, ocpiSuccess, "", location)
+		return
+	}
+	for _, evse := range location.EVSEs {
+		if evse.UID != evseUID {
+			continue
+		}
+		connectorID, ok := vars["connector_id"]
+		if !ok {
+			ocpiReply(w, http.StatusOK, ocpiSuccess, "", evse)
+			return
+		}
+		for _, connector := range evse.Connectors {
+			if connector.ID == connectorID {
+				ocpiReply(w, http.StatusOK, ocpiSuccess, "", connector)
+				return
+			}
+		}
+	}
+	ocpiReply(w, http.StatusNotFound, ocpiUnknownLocation, "unknown evse or connector", nil)
+}
+
+// ocpiTransactionsOf lists the transactions of a partner's tokens on published chargers, oldest first
+func (handler *CentralSystemHandler) ocpiTransactionsOf(partner *OCPIPartner) []*TransactionInfo {
+	list := []*TransactionInfo{}
+	for _, transaction := range handler.Transactions {
+		token, ok := handler.OCPI.Tokens[transaction.IdTag]
+		if !ok || token.Partner != partner.Name || transaction.StartTime == nil {
+			continue
+		}
+		if _, published := handler.OCPI.Locations[transaction.ChargePointID]; !published {
+			continue
+		}
+		list = append(list, transaction)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id < list[j].Id
+	})
+	return list
+}
+
+func (handler *CentralSystemHandler) ocpiCdrTokenOf(idTag string) ocpiCdrToken {
+	token := handler.OCPI.Tokens[idTag]
+	return ocpiCdrToken{CountryCode: token.CountryCode, PartyID: token.PartyID, UID: token.UID, Type: token.Type, ContractID: token.ContractID}
+}
+
+// ocpiAuthMethod tells remote started sessions from whitelisted tokens
+func (handler *CentralSystemHandler) ocpiAuthMethod(transaction *TransactionInfo) string {
+	if handler.OCPI.Tokens[transaction.IdTag].Type == "APP_USER" {
+		return "COMMAND"
+	}
+	return "WHITELIST"
+}
+
+func (handler *CentralSystemHandler) ocpiSessionOf(transaction *TransactionInfo) (*ocpiSession, time.Time) {
+	lastUpdated := time.Now()
+	session := &ocpiSession{
+		CountryCode:   handler.OCPI.CountryCode,
+		PartyID:       handler.OCPI.PartyID,
+		ID:            strconv.Itoa(transaction.Id),
+		StartDateTime: ocpiTime(transaction.StartTime.Time),
+		KWh:           float64(handler.transactionEnergy(transaction)) / 1000,
+		CdrToken:      handler.ocpiCdrTokenOf(transaction.IdTag),
+		AuthMethod:    handler.ocpiAuthMethod(transaction),
+		LocationID:    transaction.ChargePointID,
+		EvseUID:       ocpiEVSEUID(transaction.ChargePointID, transaction.ConnectorId),
+		ConnectorID:   strconv.Itoa(transaction.ConnectorId),
+		Status:        "ACTIVE",
+	}
+	if _, tariff := handler.tariffOf(transaction); tariff != nil {
+		session.Currency = tariff.Currency
+	}
+	if transaction.AuthorizationStatus != types.AuthorizationStatusAccepted {
+		session.Status = "INVALID"
+	}
+	if transaction.hasTransactionEnded() {
+		lastUpdated = transaction.EndTime.Time
+		session.EndDateTime = ocpiTime(lastUpdated)
+		if session.Status == "ACTIVE" {
+			session.Status = "COMPLETED"
+		}
+		if cdr, ok := handler.CDRs[transaction.Id]; ok {
+			session.Currency = cdr.Currency
+			session.TotalCost = &ocpiPrice{ExclVat: cdr.Total}
+		}
+	}
+	session.LastUpdated = ocpiTime(lastUpdated)
+	return session, lastUpdated
+}
+
+func (handler *CentralSystemHandler) ocpiSessions(w http.ResponseWriter, r *http.Request, partner *OCPIPartner) {
+	from, to, err := ocpiDateFilter(r)
+	if err != nil {
+		ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, err.Error(), nil)
+		return
+	}
+	sessions := []*ocpiSession{}
+	for _, transaction := range handler.ocpiTransactionsOf(partner) {
+		if session, lastUpdated := handler.ocpiSessionOf(transaction); inDateRange(lastUpdated, from, to) {
+			sessions = append(sessions, session)
+		}
+	}
+	offset, end := ocpiPage(w, r, len(sessions))
+	ocpiReply(w, http.StatusOK, ocpiSuccess, "", sessions[offset:end])
+}
+
+func (handler *CentralSystemHandler) ocpiCDROf(transaction *TransactionInfo, cdr *CDR) *ocpiCDR {
+	info := handler.OCPI.Locations[transaction.ChargePointID]
+	start, end := transaction.StartTime.Time, transaction.EndTime.Time
+	connector := info.connector(transaction.ConnectorId, "")
+	result := &ocpiCDR{
+		CountryCode:   handler.OCPI.CountryCode,
+		PartyID:       handler.OCPI.PartyID,
+		ID:            strconv.Itoa(transaction.Id),
+		StartDateTime: ocpiTime(start),
+		EndDateTime:   ocpiTime(end),
+		CdrToken:      handler.ocpiCdrTokenOf(transaction.IdTag),
+		AuthMethod:    handler.ocpiAuthMethod(transaction),
+		CdrLocation: ocpiCdrLocation{
+			ID:                 transaction.ChargePointID,
+			Name:               info.Name,
+			Address:            info.Address,
+			City:               info.City,
+			PostalCode:         info.PostalCode,
+			Country:            info.Country,
+			Coordinates:        ocpiGeoLocation{Latitude: info.Latitude, Longitude: info.Longitude},
+			EvseUID:            ocpiEVSEUID(transaction.ChargePointID, transaction.ConnectorId),
+			EvseID:             ocpiEVSEUID(transaction.ChargePointID, transaction.ConnectorId),
+			ConnectorID:        connector.ID,
+			ConnectorStandard:  connector.Standard,
+			ConnectorFormat:    connector.Format,
+			ConnectorPowerType: connector.PowerType,
+		},
+		Currency:    cdr.Currency,
+		TotalCost:   ocpiPrice{ExclVat: cdr.Total},
+		TotalEnergy: float64(cdr.EnergyWh) / 1000,
+		TotalTime:   end.Sub(start).Hours(),
+		LastUpdated: ocpiTime(end),
+	}
+	chargingEnd := end
+	if cdr.IdleSince != nil && cdr.IdleSince.After(start) && cdr.IdleSince.Before(end) {
+		chargingEnd = cdr.IdleSince.Time
+		result.TotalParkingTime = end.Sub(chargingEnd).Hours()
+	}
+	result.ChargingPeriods = []ocpiChargingPeriod{{
+		StartDateTime: ocpiTime(start),
+		Dimensions:    []ocpiCdrDimension{{Type: "ENERGY", Volume: result.TotalEnergy}, {Type: "TIME", Volume: chargingEnd.Sub(start).Hours()}},
+	}}
+	if result.TotalParkingTime > 0 {
+		result.ChargingPeriods = append(result.ChargingPeriods, ocpiChargingPeriod{
+			StartDateTime: ocpiTime(chargingEnd),
+			Dimensions:    []ocpiCdrDimension{{Type: "PARKING_TIME", Volume: result.TotalParkingTime}},
+		})
+	}
+	return result
+}
+
+func (handler *CentralSystemHandler) ocpiCDRs(w http.ResponseWriter, r *http.Request, partner *OCPIPartner) {
+	from, to, err := ocpiDateFilter(r)
+	if err != nil {
+		ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, err.Error(), nil)
+		return
+	}
+	cdrs := []*ocpiCDR{}
+	for _, transaction := range handler.ocpiTransactionsOf(partner) {
+		cdr, ok := handler.CDRs[transaction.Id]
+		if !ok || !transaction.hasTransactionEnded() || !inDateRange(transaction.EndTime.Time, from, to) {
+			continue
+		}
+		cdrs = append(cdrs, handler.ocpiCDROf(transaction, cdr))
+	}
+	offset, end := ocpiPage(w, r, len(cdrs))
+	ocpiReply(w, http.StatusOK, ocpiSuccess, "", cdrs[offset:end])
+}
+
+// storeOCPIToken keeps a token pushed by a partner, its uid becomes a valid id tag on our chargers
+func (handler *CentralSystemHandler) storeOCPIToken(partner *OCPIPartner, token *OCPIToken) error {
+	if token.UID == "" || len(token.UID) > 20 {
+		return fmt.Errorf("token uid must be 1 to 20 characters to be used as id tag")
+	}
+	if token.CountryCode != partner.CountryCode || token.PartyID != partner.PartyID {
+		return fmt.Errorf("token of %v %v can't be sent by %v", token.CountryCode, token.PartyID, partner.Name)
+	}
+	if existing, ok := handler.OCPI.Tokens[token.UID]; ok && existing.Partner != partner.Name {
+		return fmt.Errorf("token %v belongs to another partner", token.UID)
+	}
+	if _, exists := lookupIdentity(token.UID); exists {
+		return fmt.Errorf("token %v clashes with a local id tag", token.UID)
+	}
+	if token.LastUpdated == "" {
+		token.LastUpdated = ocpiTime(time.Now())
+	}
+	token.Partner = partner.Name
+	handler.OCPI.Tokens[token.UID] = token
+	log.Printf("OCPI token %v of %v stored, valid: %v", token.UID, partner.Name, token.Valid)
+	return nil
+}
+
+func (handler *CentralSystemHandler) ocpiTokens(w http.ResponseWriter, r *http.Request, partner *OCPIPartner) {
+	vars := mux.Vars(r)
+	if vars["country_code"] != partner.CountryCode || vars["party_id"] != partner.PartyID {
+		ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, "country code and party id don't match the credentials", nil)
+		return
+	}
+	existing, ok := handler.OCPI.Tokens[vars["token_uid"]]
+	if ok && existing.Partner != partner.Name {
+		ok = false
+	}
+	switch r.Method {
+	case "GET":
+		if !ok {
+			ocpiReply(w, http.StatusNotFound, ocpiUnknownToken, "unknown token", nil)
+			return
+		}
+		ocpiReply(w, http.StatusOK, ocpiSuccess, "", existing)
+		return
+	case "PUT":
+		token := &OCPIToken{}
+		if err := json.NewDecoder(r.Body).Decode(token); err != nil || token.UID != vars["token_uid"] {
+			ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, "invalid token object", nil)
+			return
+		}
+		if err := handler.storeOCPIToken(partner, token); err != nil {
+			ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, err.Error(), nil)
+			return
+		}
+	case "PATCH":
+		if !ok {
+			ocpiReply(w, http.StatusNotFound, ocpiUnknownToken, "unknown token", nil)
+			return
+		}
+		// the patch is applied on top of a copy of the stored token
+		patched := *existing
+		if err := json.NewDecoder(r.Body).Decode(&patched); err != nil || patched.UID != existing.UID {
+			ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, "invalid token patch", nil)
+			return
+		}
+		if err := handler.storeOCPIToken(partner, &patched); err != nil {
+			ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, err.Error(), nil)
+			return
+		}
+	}
+	ocpiReply(w, http.StatusOK, ocpiSuccess, "", nil)
+}
+
+// isOCPIToken tells if an id tag is a token of a roaming partner
+func (handler *CentralSystemHandler) isOCPIToken(idTag string) bool {
+	_, ok := handler.OCPI.Tokens[idTag]
+	return ok
+}
+
+// authorizeOCPIToken authorizes foreign id tags by the tokens the eMSPs pushed, ok is false for unknown ones.
+// Tokens only count on chargers published to the partners.
+func (handler *CentralSystemHandler) authorizeOCPIToken(chargePointID string, idTag string) (info *types.IdTagInfo, reason string, ok bool) {
+	token, ok := handler.OCPI.Tokens[idTag]
+	if !ok {
+		return nil, "", false
+	}
+	if _, published := handler.OCPI.Locations[chargePointID]; !published {
+		return nil, "", false
+	}
+	if !token.Valid {
+		return types.NewIdTagInfo(types.AuthorizationStatusBlocked), "OCPI token of " + token.Partner + " not valid", true
+	}
+	// without real-time authorization towards the eMSP a NEVER token needs a START_SESSION of the partner
+	if token.Whitelist == "NEVER" && token.remoteStartOn != chargePointID {
+		return types.NewIdTagInfo(types.AuthorizationStatusInvalid), "OCPI token of " + token.Partner + " needs online authorization", true
+	}
+	return types.NewIdTagInfo(types.AuthorizationStatusAccepted), "OCPI token of " + token.Partner, true
+}
+
+// ownsURL tells if a URL is below the partner's registered base URL
+func (partner *OCPIPartner) ownsURL(raw string) bool {
+	base, err := url.Parse(partner.URL)
+	if err != nil || partner.URL == "" {
+		return false
+	}
+	target, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(target.Scheme, base.Scheme) && strings.EqualFold(target.Host, base.Host) && strings.HasPrefix(target.Path, strings.TrimRight(base.Path, "/"))
+}
+
+func (handler *CentralSystemHandler) ocpiCommands(w http.ResponseWriter, r *http.Request, partner *OCPIPartner) {
+	command := mux.Vars(r)["command"]
+	request := &ocpiCommandRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil || request.ResponseURL == "" {
+		ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, "invalid command", nil)
+		return
+	}
+	// the result carries our callback token, it must not go anywhere else than to the partner
+	if !partner.ownsURL(request.ResponseURL) {
+		log.Printf("OCPI %v from %v with response_url %v outside of %v", command, partner.Name, request.ResponseURL, partner.URL)
+		ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, "response_url not at the registered partner URL", nil)
+		return
+	}
+	var execute func() ocpiCommandResult
+	switch command {
+	case "START_SESSION":
+		if request.Token == nil {
+			ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, "token missing", nil)
+			return
+		}
+		connectorID, err := ocpiConnectorOf(request.LocationID, request.EvseUID)
+		if _, published := handler.ocpiLocationOf(request.LocationID); !published || err != nil {
+			ocpiReply(w, http.StatusNotFound, ocpiUnknownLocation, "unknown location or evse", nil)
+			return
+		}
+		if err = handler.storeOCPIToken(partner, request.Token); err != nil {
+			ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, err.Error(), nil)
+			return
+		}
+		if !request.Token.Valid || handler.ChargePoints[request.LocationID].getConnector(connectorID).hasTransactionInProgress() {
+			ocpiReply(w, http.StatusOK, ocpiSuccess, "", ocpiCommandResponse{Result: "REJECTED", Timeout: ocpicommandtimeout})
+			return
+		}
+		handler.OCPI.Tokens[request.Token.UID].remoteStartOn = request.LocationID
+		execute = func() ocpiCommandResult {
+			remoteStart := core.NewRemoteStartTransactionRequest(request.Token.UID)
+			remoteStart.ConnectorId = &connectorID
+			response, err := handler.sendRequestSync(request.LocationID, remoteStart)
+			if err != nil {
+				return ocpiCommandResult{Result: "FAILED", Message: err.Error()}
+			}
+			if response.(*core.RemoteStartTransactionConfirmation).Status != types.RemoteStartStopStatusAccepted {
+				return ocpiCommandResult{Result: "REJECTED"}
+			}
+			return ocpiCommandResult{Result: "ACCEPTED"}
+		}
+	case "STOP_SESSION":
+		id, _ := strconv.Atoi(request.SessionID)
+		transaction, ok := handler.Transactions[id]
+		if ok {
+			token, known := handler.OCPI.Tokens[transaction.IdTag]
+			ok = known && token.Partner == partner.Name && !transaction.hasTransactionEnded()
+		}
+		if !ok {
+			ocpiReply(w, http.StatusOK, ocpiSuccess, "", ocpiCommandResponse{Result: "UNKNOWN_SESSION", Timeout: ocpicommandtimeout})
+			return
+		}
+		execute = func() ocpiCommandResult {
+			response, err := handler.sendRequestSync(transaction.ChargePointID, core.NewRemoteStopTransactionRequest(transaction.Id))
+			if err != nil {
+				return ocpiCommandResult{Result: "FAILED", Message: err.Error()}
+			}
+			if response.(*core.RemoteStopTransactionConfirmation).Status != types.RemoteStartStopStatusAccepted {
+				return ocpiCommandResult{Result: "REJECTED"}
+			}
+			return ocpiCommandResult{Result: "ACCEPTED"}
+		}
+	case "UNLOCK_CONNECTOR":
+		connectorID, err := ocpiConnectorOf(request.LocationID, request.EvseUID)
+		if _, published := handler.ocpiLocationOf(request.LocationID); !published || err != nil || request.ConnectorID != strconv.Itoa(connectorID) {
+			ocpiReply(w, http.StatusNotFound, ocpiUnknownLocation, "unknown location, evse or connector", nil)
+			return
+		}
+		execute = func() ocpiCommandResult {
+			response, err := handler.sendRequestSync(request.LocationID, core.NewUnlockConnectorRequest(connectorID))
+			if err != nil {
+				return ocpiCommandResult{Result: "FAILED", Message: err.Error()}
+			}
+			status := response.(*core.UnlockConnectorConfirmation).Status
+			if status != core.UnlockStatusUnlocked {
+				return ocpiCommandResult{Result: "FAILED", Message: string(status)}
+			}
+			return ocpiCommandResult{Result: "ACCEPTED"}
+		}
+	default:
+		ocpiReply(w, http.StatusOK, ocpiSuccess, "", ocpiCommandResponse{Result: "NOT_SUPPORTED", Timeout: ocpicommandtimeout})
+		return
+	}
+	log.Printf("OCPI %v from %v for %v", command, partner.Name, request.LocationID)
+	ocpiReply(w, http.StatusOK, ocpiSuccess, "", ocpiCommandResponse{Result: "ACCEPTED", Timeout: ocpicommandtimeout})
+	go func() {
+		result := execute()
+		log.Printf("OCPI %v from %v: %v %v", command, partner.Name, result.Result, result.Message)
+		if err := ocpiPost(request.ResponseURL, partner.CallbackToken, result); err != nil {
+			log.Printf("couldn't send OCPI %v result to %v: %v", command, partner.Name, err)
+		}
+	}()
+}
+
+// ocpiPost sends an object to a partner endpoint
+func ocpiPost(url string, token string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", ocpiTokenHeader(token))
+	request.Header.Set("Content-Type", "application/json")
+	client := http.Client{Timeout: confirmationtimeout * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("partner replied %v", response.Status)
+	}
+	return nil
+}
+
+// RegisterOCPIPartner Http-RPC, returns the token the partner has to use with us. partnerURL is the base URL
+// of the partner's endpoints.
+func (handler *CentralSystemHandler) RegisterOCPIPartner(name string, countryCode string, partyID string, partnerURL string, callbackToken string) (string, error) {
+	if name == "" || len(countryCode) != 2 || len(partyID) != 3 {
+		return "", fmt.Errorf("need a name, a 2 letter country code and a 3 letter party id")
+	}
+	if base, err := url.Parse(partnerURL); err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return "", fmt.Errorf("invalid partner URL %v", partnerURL)
+	}
+	token, err := randomHex(chargepointpasswordbytes)
+	if err != nil {
+		return "", err
+	}
+	handler.OCPI.Partners[name] = &OCPIPartner{Name: name, CountryCode: strings.ToUpper(countryCode), PartyID: strings.ToUpper(partyID), URL: partnerURL, Token: token, CallbackToken: callbackToken}
+	log.Printf("OCPI partner %v registered", name)
+	return token, nil
+}
+
+// DeleteOCPIPartner Http-RPC, the partner's tokens are removed as well
+func (handler *CentralSystemHandler) DeleteOCPIPartner(name string) error {
+	if _, ok := handler.OCPI.Partners[name]; !ok {
+		return fmt.Errorf("unknown OCPI partner %v", name)
+	}
+	for uid, token := range handler.OCPI.Tokens {
+		if token.Partner == name {
+			delete(handler.OCPI.Tokens, uid)
+		}
+	}
+	delete(handler.OCPI.Partners, name)
+	return nil
+}
+
+// GetOCPIPartners Http-RPC, tokens aren't shown
+func (handler *CentralSystemHandler) GetOCPIPartners() []OCPIPartner {
+	list := []OCPIPartner{}
+	for _, partner := range handler.OCPI.Partners {
+		list = append(list, OCPIPartner{Name: partner.Name, CountryCode: partner.CountryCode, PartyID: partner.PartyID, URL: partner.URL})
+	}
+	return list
+}
+
+// SetOCPIParty Http-RPC, our country code, party id and the public base URL of the /ocpi endpoints
+func (handler *CentralSystemHandler) SetOCPIParty(countryCode string, partyID string, baseURL string) error {
+	if len(countryCode) != 2 || len(partyID) != 3 {
+		return fmt.Errorf("need a 2 letter country code and a 3 letter party id")
+	}
+	handler.OCPI.CountryCode = strings.ToUpper(countryCode)
+	handler.OCPI.PartyID = strings.ToUpper(partyID)
+	handler.OCPI.BaseURL = baseURL
+	return nil
+}
+
+// SetOCPILocation Http-RPC, publishes a charger with the given location json, empty json unpublishes it
+func (handler *CentralSystemHandler) SetOCPILocation(chargePointID string, locationJSON string) error {
+	if _, err := handler.chargePointByID(chargePointID); err != nil {
+		return err
+	}
+	if locationJSON == "" {
+		delete(handler.OCPI.Locations, chargePointID)
+		return nil
+	}
+	info := &OCPILocationInfo{}
+	if err := json.Unmarshal([]byte(locationJSON), info); err != nil {
+		return fmt.Errorf("invalid location: %v", err)
+	}
+	if info.Address == "" || info.City == "" || len(info.Country) != 3 || info.Latitude == "" || info.Longitude == "" || info.TimeZone == "" {
+		return fmt.Errorf("location needs address, city, country (ISO 3166 alpha-3), latitude, longitude and time_zone")
+	}
+	handler.OCPI.Locations[chargePointID] = info
+	log.Printf("%v published as OCPI location", chargePointID)
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const ocpimockpartner = "mock-emsp"
+
+// ocpiMockEMSP is a minimal eMSP talking to our own OCPI endpoints over HTTP, for testing roaming without a partner
+type ocpiMockEMSP struct {
+	Token         string
+	CallbackToken string
+	Results       []ocpiMockResult
+}
+
+// ocpiMockResult is a command result the mock eMSP received
+type ocpiMockResult struct {
+	Command  string            `json:"command"`
+	Received string            `json:"received"`
+	Result   ocpiCommandResult `json:"result"`
+}
+
+func (handler *CentralSystemHandler) mockEMSP() (*ocpiMockEMSP, error) {
+	if handler.ocpiMock == nil {
+		return nil, fmt.Errorf("mock eMSP not set up, call ocpiMockSetup first")
+	}
+	return handler.ocpiMock, nil
+}
+
+// OCPIMockSetup Http-RPC, registers the mock eMSP as partner NL-MCK
+func (handler *CentralSystemHandler) OCPIMockSetup() (string, error) {
+	callbackToken, err := randomHex(chargepointpasswordbytes)
+	if err != nil {
+		return "", err
+	}
+	baseURL, err := handler.ocpiBaseURL()
+	if err != nil {
+		return "", err
+	}
+	token, err := handler.RegisterOCPIPartner(ocpimockpartner, "NL", "MCK", baseURL+"/mock", callbackToken)
+	if err != nil {
+		return "", err
+	}
+	handler.ocpiMock = &ocpiMockEMSP{Token: token, CallbackToken: callbackToken, Results: []ocpiMockResult{}}
+	return token, nil
+}
+
+// request calls one of our OCPI endpoints as the mock eMSP and returns the decoded reply
+func (mock *ocpiMockEMSP) request(handler *CentralSystemHandler, method string, path string, body interface{}) (interface{}, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	baseURL, err := handler.ocpiBaseURL()
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(method, baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", ocpiTokenHeader(mock.Token))
+	request.Header.Set("Content-Type", "application/json")
+	client := http.Client{Timeout: confirmationtimeout * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	var reply interface{}
+	if err = json.Unmarshal(content, &reply); err != nil {
+		return nil, fmt.Errorf("%v: %v", response.Status, string(content))
+	}
+	return reply, nil
+}
+
+// OCPIMockPushToken Http-RPC, the mock eMSP pushes a valid RFID token
+func (handler *CentralSystemHandler) OCPIMockPushToken(uid string) (interface{}, error) {
+	mock, err := handler.mockEMSP()
+	if err != nil {
+		return nil, err
+	}
+	token := OCPIToken{CountryCode: "NL", PartyID: "MCK", UID: uid, Type: "RFID", ContractID: "NL-MCK-C" + uid, Issuer: "Mock eMSP", Valid: true, Whitelist: "ALWAYS", LastUpdated: ocpiTime(time.Now())}
+	return mock.request(handler, "PUT", "/2.2/tokens/NL/MCK/"+uid, token)
+}
+
+// OCPIMockCommand Http-RPC, the mock eMSP sends START_SESSION (uid, location, evse), STOP_SESSION (session id)
+// or UNLOCK_CONNECTOR (location, evse, connector), results arrive at the mock callback
+func (handler *CentralSystemHandler) OCPIMockCommand(command string, params []string) (interface{}, error) {
+	mock, err := handler.mockEMSP()
+	if err != nil {
+		return nil, err
+	}
+	baseURL, err := handler.ocpiBaseURL()
+	if err != nil {
+		return nil, err
+	}
+	request := ocpiCommandRequest{ResponseURL: baseURL + "/mock/callback/" + command}
+	switch {
+	case command == "START_SESSION" && len(params) == 3:
+		request.Token = &OCPIToken{CountryCode: "NL", PartyID: "MCK", UID: params[0], Type: "APP_USER", ContractID: "NL-MCK-C" + params[0], Issuer: "Mock eMSP", Valid: true, Whitelist: "ALLOWED", LastUpdated: ocpiTime(time.Now())}
+		request.LocationID = params[1]
+		request.EvseUID = params[2]
+	case command == "STOP_SESSION" && len(params) == 1:
+		request.SessionID = params[0]
+	case command == "UNLOCK_CONNECTOR" && len(params) == 3:
+		request.LocationID = params[0]
+		request.EvseUID = params[1]
+		request.ConnectorID = params[2]
+	default:
+		return nil, fmt.Errorf("unknown command %v or wrong number of params", command)
+	}
+	return mock.request(handler, "POST", "/2.2/commands/"+command, request)
+}
+
+// OCPIMockGet Http-RPC, the mock eMSP fetches a module: versions, locations, sessions or cdrs
+func (handler *CentralSystemHandler) OCPIMockGet(module string, offset int) (interface{}, error) {
+	mock, err := handler.mockEMSP()
+	if err != nil {
+		return nil, err
+	}
+	switch module {
+	case "versions":
+		return mock.request(handler, "GET", "/versions", nil)
+	case "locations", "sessions", "cdrs":
+		return mock.request(handler, "GET", "/2.2/"+module+"?offset="+strconv.Itoa(offset), nil)
+	}
+	return nil, fmt.Errorf("unknown module %v", module)
+}
+
+// OCPIMockResults Http-RPC, the command results the mock eMSP received
+func (handler *CentralSystemHandler) OCPIMockResults() ([]ocpiMockResult, error) {
+	mock, err := handler.mockEMSP()
+	if err != nil {
+		return nil, err
+	}
+	return mock.Results, nil
+}
+
+// ocpiMockCallback receives command results as the mock eMSP
+func (handler *CentralSystemHandler) ocpiMockCallback(w http.ResponseWriter, r *http.Request) {
+	mock := handler.ocpiMock
+	if mock == nil || r.Header.Get("Authorization") != ocpiTokenHeader(mock.CallbackToken) {
+		ocpiReply(w, http.StatusUnauthorized, ocpiClientError, "unknown token", nil)
+		return
+	}
+	var result ocpiCommandResult
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		ocpiReply(w, http.StatusBadRequest, ocpiInvalidParameters, "invalid command result", nil)
+		return
+	}
+	mock.Results = append(mock.Results, ocpiMockResult{Command: mux.Vars(r)["command"], Received: ocpiTime(time.Now()), Result: result})
+	ocpiReply(w, http.StatusOK, ocpiSuccess, "", nil)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/core"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/remotetrigger"
+)
+
+// OperationResult is the structured Http-RPC reply of a remote operation on a charge point
+type OperationResult struct {
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// operation sends a request, waits for the confirmation and converts it into an OperationResult
+func (handler *CentralSystemHandler) operation(chargePointID string, request ocpp.Request) OperationResult {
+	if _, err := handler.chargePointByID(chargePointID); err != nil {
+		return OperationResult{Status: "Error", Error: err.Error()}
+	}
+	response, err := handler.sendRequestSync(chargePointID, request)
+	if err != nil {
+		logDefault(chargePointID, request.GetFeatureName()).Errorf("error on request: %v", err)
+		return OperationResult{Status: "Error", Error: err.Error()}
+	}
+	var result OperationResult
+	switch confirmation := response.(type) {
+	case *core.ResetConfirmation:
+		result.Status = string(confirmation.Status)
+	case *core.ChangeAvailabilityConfirmation:
+		result.Status = string(confirmation.Status)
+	case *core.ClearCacheConfirmation:
+		result.Status = string(confirmation.Status)
+	case *remotetrigger.TriggerMessageConfirmation:
+		result.Status = string(confirmation.Status)
+	case *core.GetConfigurationConfirmation:
+		result.Status = "Accepted"
+		configuration := make(map[string]ConfigurationValue, len(confirmation.ConfigurationKey))
+		for _, key := range confirmation.ConfigurationKey {
+			configuration[key.Key] = ConfigurationValue{Value: key.Value, Readonly: key.Readonly}
+		}
+		result.Details = map[string]interface{}{"configuration": configuration, "unknown_keys": confirmation.UnknownKey}
+	default:
+		result.Status = "Accepted"
+		result.Details = response
+	}
+	logDefault(chargePointID, request.GetFeatureName()).Infof("operation confirmed with %v", result.Status)
+	return result
+}
+
+// groupOperation runs an operation on every charger of a DLM group in parallel
+func (handler *CentralSystemHandler) groupOperation(groupID string, op func(chargePointID string) OperationResult) (map[string]OperationResult, error) {
+	group, ok := handler.Groups[groupID]
+	if !ok {
+		return nil, fmt.Errorf("unknown group %v", groupID)
+	}
+	results := make(map[string]OperationResult, len(group.Chargers))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for name := range group.Chargers {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			result := op(name)
+			mutex.Lock()
+			results[name] = result
+			mutex.Unlock()
+		}(name)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// Reset Http-RPC, resetType is Soft or Hard
+func (handler *CentralSystemHandler) Reset(chargePointID string, resetType string) OperationResult {
+	return handler.operation(chargePointID, core.NewResetRequest(core.ResetType(resetType)))
+}
+
+// ChangeAvailability Http-RPC, connector 0 addresses the whole charge point, availabilityType is Operative or Inoperative
+func (handler *CentralSystemHandler) ChangeAvailability(chargePointID string, connectorID int, availabilityType string) OperationResult {
+	return handler.operation(chargePointID, core.NewChangeAvailabilityRequest(connectorID, core.AvailabilityType(availabilityType)))
+}
+
+// ClearCache Http-RPC
+func (handler *CentralSystemHandler) ClearCache(chargePointID string) OperationResult {
+	return handler.operation(chargePointID, core.NewClearCacheRequest())
+}
+
+// GetConfigurationKeys Http-RPC, asks the charge point directly, no keys returns the full configuration
+func (handler *CentralSystemHandler) GetConfigurationKeys(chargePointID string, keys []string) OperationResult {
+	return handler.operation(chargePointID, core.NewGetConfigurationRequest(keys))
+}
+
+// TriggerMessage Http-RPC, connectorID 0 doesn't address a connector
+func (handler *CentralSystemHandler) TriggerMessage(chargePointID string, message string, connectorID int) OperationResult {
+	request := remotetrigger.NewTriggerMessageRequest(remotetrigger.MessageTrigger(message))
+	if connectorID > 0 {
+		request.ConnectorId = &connectorID
+	}
+	return handler.operation(chargePointID, request)
+}
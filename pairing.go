@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+const (
+	PairingWaitingForCard    = "WaitingForCard"
+	PairingWaitingForVehicle = "WaitingForVehicle"
+	PairingPaired            = "Paired"
+	PairingExpired           = "Expired"
+	PairingCancelled         = "Cancelled"
+)
+
+// PairingSession is the learn mode of a charger: the next MAC seen after a known card is linked to that card's account
+type PairingSession struct {
+	ChargePointID string          `json:"charge_point_id"`
+	Status        string          `json:"status"`
+	CardIdTag     string          `json:"card_id_tag"`
+	PairedMAC     string          `json:"paired_mac"`
+	Started       *types.DateTime `json:"started"`
+	Expires       *types.DateTime `json:"expires"`
+}
+
+func (session *PairingSession) isActive() bool {
+	if session.Status != PairingWaitingForCard && session.Status != PairingWaitingForVehicle {
+		return false
+	}
+	if session.Expires.Before(time.Now()) {
+		session.Status = PairingExpired
+		log.WithField("client", session.ChargePointID).Info("pairing mode expired")
+		return false
+	}
+	return true
+}
+
+// StartPairing Http-RPC, enables learn mode on a charger for the given minutes
+func (handler *CentralSystemHandler) StartPairing(chargePointID string, minutes int) (*PairingSession, error) {
+	if _, err := handler.chargePointByID(chargePointID); err != nil {
+		return nil, err
+	}
+	if minutes < 1 || minutes > maxpairingminutes {
+		return nil, fmt.Errorf("pairing mode lasts 1 to %v minutes", maxpairingminutes)
+	}
+	session := &PairingSession{
+		ChargePointID: chargePointID,
+		Status:        PairingWaitingForCard,
+		Started:       types.NewDateTime(time.Now()),
+		Expires:       types.NewDateTime(time.Now().Add(time.Duration(minutes) * time.Minute)),
+	}
+	handler.Pairings[chargePointID] = session
+	log.WithField("client", chargePointID).Infof("pairing mode started for %v minutes", minutes)
+	return session, nil
+}
+
+// CancelPairing Http-RPC
+func (handler *CentralSystemHandler) CancelPairing(chargePointID string) error {
+	session, ok := handler.Pairings[chargePointID]
+	if !ok || !session.isActive() {
+		return fmt.Errorf("no active pairing on %v", chargePointID)
+	}
+	session.Status = PairingCancelled
+	return nil
+}
+
+// GetPairings Http-RPC
+func (handler *CentralSystemHandler) GetPairings() map[string]*PairingSession {
+	for _, session := range handler.Pairings {
+		session.isActive()
+	}
+	return handler.Pairings
+}
+
+// handlePairing is called for every id tag a charger presents, before authorization
+func (handler *CentralSystemHandler) handlePairing(chargePointID string, idTag string) {
+	session, ok := handler.Pairings[chargePointID]
+	if !ok || !session.isActive() {
+		return
+	}
+	key, isMac := splitIdTag(idTag)
+	switch {
+	case session.Status == PairingWaitingForCard && !isMac:
+		if _, exists := identity.Cards[key]; !exists {
+			log.WithField("client", chargePointID).Infof("pairing ignores unknown card %v", idTag)
+			return
+		}
+		// blocked, expired or over quota cards and cards of a blocked account can't pair
+		if info, reason := handler.authorizeIdTag(idTag); info.Status != types.AuthorizationStatusAccepted {
+			log.WithField("client", chargePointID).Infof("pairing ignores card %v: %v", idTag, reason)
+			return
+		}
+		session.CardIdTag = idTag
+		session.Status = PairingWaitingForVehicle
+		log.WithField("client", chargePointID).Infof("pairing card %v, waiting for vehicle", idTag)
+	case session.Status == PairingWaitingForVehicle && isMac && key != "":
+		card := identity.Cards[session.CardIdTag]
+		// the MAC joins the card's account, which is the card's parent if it has one
+		account := session.CardIdTag
+		if card.ParentIdTag != "" {
+			account = card.ParentIdTag
+		}
+		macs := identityMap(true)
+		mac, exists := macs[key]
+		if !exists {
+			mac = authIdStruct{TXList: map[string]TransactionInfo{}}
+		} else if !mac.Authorized {
+			log.WithField("client", chargePointID).Warnf("pairing refused, vehicle %v is blocked", idTag)
+			return
+		} else if mac.ParentIdTag != account {
+			// a vehicle without parent is a standalone identity of its own, it's not free to take
+			log.WithField("client", chargePointID).Warnf("pairing refused, vehicle %v isn't part of account %v", idTag, account)
+			return
+		}
+		mac.Authorized = true
+		mac.ParentIdTag = account
+		mac.Owner = card.Owner
+		if mac.Label == "" {
+			mac.Label = "paired with " + session.CardIdTag + " on " + chargePointID
+		}
+		macs[key] = mac
+		session.PairedMAC = idTag
+		session.Status = PairingPaired
+		log.WithField("client", chargePointID).Infof("paired vehicle %v with account %v", idTag, account)
+		_ = handler.commitIdentity()
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+const (
+	EventMeterAnomaly = "MeterAnomaly"
+
+	AnomalyRegisterBackwards   = "RegisterBackwards"
+	AnomalyRegisterBelowStart  = "RegisterBelowStart"
+	AnomalyEnergyJump          = "EnergyJump"
+	AnomalyCurrentAboveOffered = "CurrentAboveOffered"
+	AnomalyMeterStopBelowStart = "MeterStopBelowStart"
+)
+
+// PlausibilityRules of a charger, a zero value switches a limit off
+type PlausibilityRules struct {
+	// MaxCurrentExcess is how many A a phase current may be above the current offered
+	MaxCurrentExcess int `json:"max_current_excess"`
+	// MaxEnergyJumpWh is the largest increase of the energy register between two meter values
+	MaxEnergyJumpWh int64 `json:"max_energy_jump_wh"`
+}
+
+// Anomaly is an implausible meter reading, repeats of the same rule within a transaction are counted
+type Anomaly struct {
+	Rule          string          `json:"rule"`
+	ConnectorId   int             `json:"connector_id"`
+	TransactionId int             `json:"transaction_id"`
+	FirstSeen     *types.DateTime `json:"first_seen"`
+	LastSeen      *types.DateTime `json:"last_seen"`
+	Count         int             `json:"count"`
+	Value         float64         `json:"value"`
+	Expected      float64         `json:"expected"`
+	Message       string          `json:"message"`
+}
+
+var defaultPlausibilityRules = PlausibilityRules{MaxCurrentExcess: plausibilitycurrentexcess}
+
+func (cp *ChargePointState) plausibilityRules() PlausibilityRules {
+	if cp.Plausibility == nil {
+		return defaultPlausibilityRules
+	}
+	return *cp.Plausibility
+}
+
+// checkReading tells if a reading is plausible, implausible ones are flagged and must not be used for DLM
+func (handler *CentralSystemHandler) checkReading(chargePointID string, connectorID int, transactionID int, reading MeterReading) bool {
+	cp := handler.ChargePoints[chargePointID]
+	rules := cp.plausibilityRules()
+	anomaly := Anomaly{ConnectorId: connectorID, TransactionId: transactionID, Value: reading.Value}
+	// connectors of chargers with more than one connector may have meters of their own
+	register, offered := cp.EnergyMeterCurrent, cp.CurrentOffered
+	if connector := cp.meterConnector(connectorID); connector != nil {
+		register, offered = connector.EnergyMeterCurrent, connector.CurrentOffered
+	}
+	switch reading.Measurand {
+	case measurandEnergyImport:
+		if reading.Phase != "" {
+			// phase registers aren't comparable with the total register
+			break
+		}
+		transaction, ok := handler.Transactions[transactionID]
+		if ok && reading.Value < float64(transaction.StartMeter) {
+			anomaly.Rule, anomaly.Expected = AnomalyRegisterBelowStart, float64(transaction.StartMeter)
+			anomaly.Message = fmt.Sprintf("energy register %v Wh below meter start %v Wh", reading.Value, transaction.StartMeter)
+		} else if register > 0 && reading.Value < float64(register) {
+			anomaly.Rule, anomaly.Expected = AnomalyRegisterBackwards, float64(register)
+			anomaly.Message = fmt.Sprintf("energy register went back from %v Wh to %v Wh", register, reading.Value)
+		} else if rules.MaxEnergyJumpWh > 0 && register > 0 && reading.Value-float64(register) > float64(rules.MaxEnergyJumpWh) {
+			anomaly.Rule, anomaly.Expected = AnomalyEnergyJump, float64(register+rules.MaxEnergyJumpWh)
+			anomaly.Message = fmt.Sprintf("energy register jumped from %v Wh to %v Wh", register, reading.Value)
+		}
+	case measurandCurrent:
+		limit := offered + rules.MaxCurrentExcess
+		if rules.MaxCurrentExcess > 0 && offered > 0 && reading.Value > float64(limit) {
+			anomaly.Rule, anomaly.Expected = AnomalyCurrentAboveOffered, float64(limit)
+			anomaly.Message = fmt.Sprintf("current %v A on %v with %v A offered", reading.Value, phaseOf(reading.Phase), offered)
+		}
+	}
+	if anomaly.Rule == "" {
+		if reading.Measurand == measurandEnergyImport && reading.Phase == "" {
+			delete(cp.pendingRegisters, connectorID)
+		}
+		return true
+	}
+	handler.flagAnomaly(chargePointID, anomaly)
+	if reading.Measurand == measurandEnergyImport && cp.rebaselineRegister(connectorID, reading.Value, rules) {
+		log.WithField("client", chargePointID).Warnf("energy register of connector %v re-baselined to %v Wh after %v consistent readings", connectorID, reading.Value, plausibilityrebaselinereadings)
+		return true
+	}
+	return false
+}
+
+// pendingRegister is a flagged register reading that may become the new reference
+type pendingRegister struct {
+	value float64
+	count int
+}
+
+// rebaselineRegister tells if flagged register readings have agreed with each other often enough to be
+// taken as the new reference, e.g. after an offline period or a meter swap
+func (cp *ChargePointState) rebaselineRegister(connectorID int, value float64, rules PlausibilityRules) bool {
+	if cp.pendingRegisters == nil {
+		cp.pendingRegisters = map[int]*pendingRegister{}
+	}
+	pending, ok := cp.pendingRegisters[connectorID]
+	consistent := ok && value >= pending.value && (rules.MaxEnergyJumpWh == 0 || value-pending.value <= float64(rules.MaxEnergyJumpWh))
+	if !consistent {
+		cp.pendingRegisters[connectorID] = &pendingRegister{value: value, count: 1}
+		return false
+	}
+	pending.value = value
+	pending.count++
+	if pending.count < plausibilityrebaselinereadings {
+		return false
+	}
+	delete(cp.pendingRegisters, connectorID)
+	return true
+}
+
+// hasAnomaly tells if a transaction was flagged for a rule
+func (ti *TransactionInfo) hasAnomaly(rule string) bool {
+	for _, flagged := range ti.Anomalies {
+		if flagged == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// meteredEnergy is the energy of a finished transaction, none if its meter stop is below its meter start
+func (ti *TransactionInfo) meteredEnergy() int64 {
+	if ti.hasAnomaly(AnomalyMeterStopBelowStart) || ti.EndMeter < ti.StartMeter {
+		return 0
+	}
+	return int64(ti.EndMeter - ti.StartMeter)
+}
+
+// checkMeterStop flags a transaction whose meter stop is below its meter start
+func (handler *CentralSystemHandler) checkMeterStop(chargePointID string, transaction *TransactionInfo) {
+	if transaction.EndMeter >= transaction.StartMeter {
+		return
+	}
+	handler.flagAnomaly(chargePointID, Anomaly{
+		Rule:          AnomalyMeterStopBelowStart,
+		ConnectorId:   transaction.ConnectorId,
+		TransactionId: transaction.Id,
+		Value:         float64(transaction.EndMeter),
+		Expected:      float64(transaction.StartMeter),
+		Message:       fmt.Sprintf("meter stop %v Wh below meter start %v Wh", transaction.EndMeter, transaction.StartMeter),
+	})
+}
+
+// flagAnomaly records an anomaly on the charger and its transaction, the operator gets an event for the
+// first one of a rule per transaction
+func (handler *CentralSystemHandler) flagAnomaly(chargePointID string, anomaly Anomaly) {
+	cp := handler.ChargePoints[chargePointID]
+	now := types.NewDateTime(time.Now())
+	cp.MeterFlagged = true
+	for _, known := range cp.Anomalies {
+		if known.Rule == anomaly.Rule && known.TransactionId == anomaly.TransactionId && known.ConnectorId == anomaly.ConnectorId {
+			known.Count++
+			known.LastSeen, known.Value, known.Expected, known.Message = now, anomaly.Value, anomaly.Expected, anomaly.Message
+			return
+		}
+	}
+	anomaly.FirstSeen, anomaly.LastSeen, anomaly.Count = now, now, 1
+	cp.Anomalies = append(cp.Anomalies, &anomaly)
+	if len(cp.Anomalies) > maxanomalies {
+		cp.Anomalies = cp.Anomalies[len(cp.Anomalies)-maxanomalies:]
+	}
+	event := Event{Type: EventMeterAnomaly, Audience: EventAudienceOperator, ChargePointID: chargePointID, ConnectorId: anomaly.ConnectorId, TransactionId: anomaly.TransactionId, Message: anomaly.Message}
+	if transaction, ok := handler.Transactions[anomaly.TransactionId]; ok {
+		transaction.Anomalies = append(transaction.Anomalies, anomaly.Rule)
+		event.IdTag = transaction.IdTag
+	}
+	handler.raiseEvent(event)
+}
+
+// SetPlausibilityRules Http-RPC, empty rules restore the defaults
+func (handler *CentralSystemHandler) SetPlausibilityRules(chargePointID string, rulesJSON string) (PlausibilityRules, error) {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return PlausibilityRules{}, err
+	}
+	if rulesJSON == "" {
+		cp.Plausibility = nil
+		return cp.plausibilityRules(), nil
+	}
+	rules := &PlausibilityRules{}
+	if err = json.Unmarshal([]byte(rulesJSON), rules); err != nil {
+		return PlausibilityRules{}, fmt.Errorf("invalid plausibility rules: %v", err)
+	}
+	if rules.MaxCurrentExcess < 0 || rules.MaxEnergyJumpWh < 0 {
+		return PlausibilityRules{}, fmt.Errorf("limits can't be negative")
+	}
+	cp.Plausibility = rules
+	log.WithField("client", chargePointID).Infof("plausibility rules set to %+v", *rules)
+	return *rules, nil
+}
+
+// GetAnomalies Http-RPC, anomalies of a charger or of all flagged chargers
+func (handler *CentralSystemHandler) GetAnomalies(chargePointID string) (map[string][]*Anomaly, error) {
+	list := map[string][]*Anomaly{}
+	if chargePointID != "" {
+		cp, err := handler.chargePointByID(chargePointID)
+		if err != nil {
+			return nil, err
+		}
+		list[chargePointID] = cp.Anomalies
+		return list, nil
+	}
+	for name, cp := range handler.ChargePoints {
+		if cp.MeterFlagged {
+			list[name] = cp.Anomalies
+		}
+	}
+	return list, nil
+}
+
+// ClearAnomalies Http-RPC, the operator checked the charger, transactions keep their flags. The energy
+// registers are re-baselined, the next reading is taken as reference.
+func (handler *CentralSystemHandler) ClearAnomalies(chargePointID string) error {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return err
+	}
+	cp.Anomalies = nil
+	cp.MeterFlagged = false
+	cp.EnergyMeterCurrent = 0
+	for _, connector := range cp.Connectors {
+		connector.EnergyMeterCurrent = 0
+	}
+	cp.pendingRegisters = nil
+	log.WithField("client", chargePointID).Info("meter anomalies cleared")
+	return nil
+}
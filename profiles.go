@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/core"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+const defaultConfigProfile = "default"
+
+// ConfigProfile is a named set of configuration keys pushed to chargers with ChangeConfiguration
+type ConfigProfile struct {
+	Settings map[string]string `json:"settings"`
+}
+
+// ConfigurationResult is the outcome of the last ChangeConfiguration of a key
+type ConfigurationResult struct {
+	Value  string                   `json:"value"`
+	Status core.ConfigurationStatus `json:"status"`
+	Error  string                   `json:"error"`
+	Time   *types.DateTime          `json:"time"`
+}
+
+// Default profile applied to every charger, the group and charger profiles are layered on top
+func newDefaultConfigProfile() *ConfigProfile {
+	return &ConfigProfile{Settings: map[string]string{
+		"MeterValueSampleInterval": "10",
+		// Refused StartTransactions must end the session
+		"StopTransactionOnInvalidId": "true",
+		// Supported by JuiceMe, maximum data
+		"MeterValuesSampledData": "Current.Import.L1,Current.Import.L2,Current.Import.L3,Current.Offered,Energy.Active.Import.Register,Power.Active.Import",
+	}}
+}
+
+// effectiveConfiguration merges the default, group and charger profile of a charge point
+func (handler *CentralSystemHandler) effectiveConfiguration(chargePointID string) map[string]string {
+	settings := make(map[string]string)
+	layers := []string{defaultConfigProfile}
+	if cp, ok := handler.ChargePoints[chargePointID]; ok {
+		if group, ok := handler.Groups[cp.DLMGroup]; ok && group.ConfigProfile != "" {
+			layers = append(layers, group.ConfigProfile)
+		}
+		if cp.ConfigProfile != "" {
+			layers = append(layers, cp.ConfigProfile)
+		}
+	}
+	for _, name := range layers {
+		profile, ok := handler.ConfigProfiles[name]
+		if !ok {
+			log.WithField("client", chargePointID).Warnf("config profile %v doesn't exist", name)
+			continue
+		}
+		for key, value := range profile.Settings {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+// changeConfiguration sends a single ChangeConfiguration and waits for the outcome
+func (handler *CentralSystemHandler) changeConfiguration(chargePointID string, key string, value string) (core.ConfigurationStatus, error) {
+	response, err := handler.sendRequestSync(chargePointID, core.NewChangeConfigurationRequest(key, value))
+	if err != nil {
+		return "", err
+	}
+	return response.(*core.ChangeConfigurationConfirmation).Status, nil
+}
+
+// ReconcileConfiguration pushes every key of the effective profile the charger doesn't have yet.
+// Keys already at the wanted value, or refused before for the same value, are skipped.
+func (handler *CentralSystemHandler) ReconcileConfiguration(chargePointID string) error {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return err
+	}
+	handler.configMutex.Lock()
+	defer handler.configMutex.Unlock()
+	if cp.ConfigurationResults == nil {
+		cp.ConfigurationResults = map[string]*ConfigurationResult{}
+	}
+	for key, value := range handler.effectiveConfiguration(chargePointID) {
+		if current, ok := cp.Configuration[key]; ok && current.Value != nil && *current.Value == value {
+			cp.ConfigurationResults[key] = &ConfigurationResult{Value: value, Status: core.ConfigurationStatusAccepted, Time: types.NewDateTime(time.Now())}
+			continue
+		}
+		if last, ok := cp.ConfigurationResults[key]; ok && last.Value == value && (last.Status == core.ConfigurationStatusRejected || last.Status == core.ConfigurationStatusNotSupported) {
+			continue
+		}
+		result := &ConfigurationResult{Value: value, Time: types.NewDateTime(time.Now())}
+		result.Status, err = handler.changeConfiguration(chargePointID, key, value)
+		if err != nil {
+			result.Error = err.Error()
+			logDefault(chargePointID, core.ChangeConfigurationFeatureName).Errorf("error on request for key %v: %v", key, err)
+		} else {
+			logDefault(chargePointID, core.ChangeConfigurationFeatureName).Infof("configuration key %v to %v: %v", key, value, result.Status)
+		}
+		if result.Status == core.ConfigurationStatusAccepted || result.Status == core.ConfigurationStatusRebootRequired {
+			if cp.Configuration == nil {
+				cp.Configuration = map[string]ConfigurationValue{}
+			}
+			applied := value
+			cp.Configuration[key] = ConfigurationValue{Value: &applied, Readonly: false}
+		}
+		cp.ConfigurationResults[key] = result
+	}
+	return nil
+}
+
+// reconcileConnected reconciles all connected and accepted chargers, the RPC returns once all are done
+func (handler *CentralSystemHandler) reconcileConnected() {
+	chargers := make([]string, 0, len(handler.setupStarted))
+	for name := range handler.setupStarted {
+		chargers = append(chargers, name)
+	}
+	for _, name := range chargers {
+		_ = handler.ReconcileConfiguration(name)
+	}
+}
+
+// GetConfigProfiles Http-RPC
+func (handler *CentralSystemHandler) GetConfigProfiles() map[string]*ConfigProfile {
+	return handler.ConfigProfiles
+}
+
+// SetConfigProfile Http-RPC, settings is a JSON object of key -> value
+func (handler *CentralSystemHandler) SetConfigProfile(name string, settings string) error {
+	profile := &ConfigProfile{}
+	if err := json.Unmarshal([]byte(settings), &profile.Settings); err != nil {
+		return fmt.Errorf("invalid settings: %v", err)
+	}
+	handler.ConfigProfiles[name] = profile
+	handler.reconcileConnected()
+	return nil
+}
+
+// DeleteConfigProfile Http-RPC, the default profile and profiles still assigned can't be deleted
+func (handler *CentralSystemHandler) DeleteConfigProfile(name string) error {
+	if name == defaultConfigProfile {
+		return fmt.Errorf("can't delete the %v profile", defaultConfigProfile)
+	}
+	if _, ok := handler.ConfigProfiles[name]; !ok {
+		return fmt.Errorf("unknown config profile %v", name)
+	}
+	for id, group := range handler.Groups {
+		if group.ConfigProfile == name {
+			return fmt.Errorf("config profile %v is assigned to group %v", name, id)
+		}
+	}
+	for id, cp := range handler.ChargePoints {
+		if cp.ConfigProfile == name {
+			return fmt.Errorf("config profile %v is assigned to %v", name, id)
+		}
+	}
+	delete(handler.ConfigProfiles, name)
+	return nil
+}
+
+// AssignConfigProfile Http-RPC, target is "group" or "chargepoint", an empty name removes the assignment
+func (handler *CentralSystemHandler) AssignConfigProfile(target string, id string, name string) error {
+	if _, ok := handler.ConfigProfiles[name]; name != "" && !ok {
+		return fmt.Errorf("unknown config profile %v", name)
+	}
+	switch target {
+	case "group":
+		group, ok := handler.Groups[id]
+		if !ok {
+			return fmt.Errorf("unknown group %v", id)
+		}
+		group.ConfigProfile = name
+	case "chargepoint":
+		cp, err := handler.chargePointByID(id)
+		if err != nil {
+			return err
+		}
+		cp.ConfigProfile = name
+	default:
+		return fmt.Errorf("unknown target %v, use group or chargepoint", target)
+	}
+	handler.reconcileConnected()
+	return nil
+}
+
+// GetConfigurationResults Http-RPC
+func (handler *CentralSystemHandler) GetConfigurationResults(chargePointID string) (map[string]*ConfigurationResult, error) {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return nil, err
+	}
+	return cp.ConfigurationResults, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/core"
+)
+
+const (
+	QuotaPeriodMonthly = "monthly"
+	QuotaPeriodTotal   = "total"
+)
+
+// QuotaStatus Http-RPC reply, the quota of an identity and the quota of its parent account if it has one
+type QuotaStatus struct {
+	IdTag       string       `json:"id_tag"`
+	Period      string       `json:"period"`
+	QuotaWh     int64        `json:"quota_wh"`
+	UsedWh      int64        `json:"used_wh"`
+	RemainingWh int64        `json:"remaining_wh"`
+	Exhausted   bool         `json:"exhausted"`
+	Parent      *QuotaStatus `json:"parent,omitempty"`
+}
+
+// periodStart returns the begin of the current quota period
+func periodStart(period string, now time.Time) time.Time {
+	if period == QuotaPeriodMonthly {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Time{}
+}
+
+// accountIdTags returns the id tag and all id tags having it as parent
+func accountIdTags(idTag string) map[string]bool {
+	tags := map[string]bool{idTag: true}
+	for tag, auth := range identity.Cards {
+		if auth.ParentIdTag == idTag {
+			tags[tag] = true
+		}
+	}
+	for mac, auth := range identity.MACs {
+		if auth.ParentIdTag == idTag {
+			tags["MAC"+mac] = true
+		}
+	}
+	return tags
+}
+
+// transactionEnergy is the energy of a finished transaction or the energy so far of a running one
+func (handler *CentralSystemHandler) transactionEnergy(transaction *TransactionInfo) int64 {
+	var energy int64
+	if transaction.hasTransactionEnded() {
+		energy = transaction.meteredEnergy()
+	} else if cp, ok := handler.ChargePoints[transaction.ChargePointID]; ok {
+		if connector, ok := cp.Connectors[transaction.ConnectorId]; ok && connector.CurrentTransaction == transaction.Id {
+			energy = cp.EnergyMeterCurrent - int64(transaction.StartMeter)
+			if connector.EnergyMeterCurrent > 0 {
+				energy = connector.EnergyMeterCurrent - int64(transaction.StartMeter)
+			}
+		}
+	}
+	if energy < 0 {
+		return 0
+	}
+	return energy
+}
+
+// energyUsed sums up the energy of all transactions of the id tags started since the given time
+func (handler *CentralSystemHandler) energyUsed(tags map[string]bool, since time.Time) int64 {
+	var used int64
+	for _, transaction := range handler.Transactions {
+		if !tags[transaction.IdTag] || transaction.StartTime == nil || transaction.StartTime.Before(since) {
+			continue
+		}
+		used += handler.transactionEnergy(transaction)
+	}
+	return used
+}
+
+func (handler *CentralSystemHandler) quotaOf(idTag string, auth authIdStruct) *QuotaStatus {
+	status := &QuotaStatus{IdTag: idTag, Period: auth.QuotaPeriod, QuotaWh: auth.QuotaWh}
+	status.UsedWh = handler.energyUsed(accountIdTags(idTag), periodStart(auth.QuotaPeriod, time.Now()))
+	if auth.QuotaWh > 0 {
+		status.RemainingWh = auth.QuotaWh - status.UsedWh
+		status.Exhausted = status.RemainingWh <= 0
+	}
+	return status
+}
+
+// GetQuota Http-RPC
+func (handler *CentralSystemHandler) GetQuota(idTag string) (*QuotaStatus, error) {
+	auth, exists := lookupIdentity(idTag)
+	if !exists {
+		return nil, fmt.Errorf("unknown id tag %v", idTag)
+	}
+	status := handler.quotaOf(idTag, auth)
+	if parent, exists := lookupIdentity(auth.ParentIdTag); auth.ParentIdTag != "" && exists {
+		status.Parent = handler.quotaOf(auth.ParentIdTag, parent)
+	}
+	return status, nil
+}
+
+// SetQuota Http-RPC, a quota of 0 removes it
+func (handler *CentralSystemHandler) SetQuota(idTag string, quotaWh int64, period string) (*QuotaStatus, error) {
+	auth, exists := lookupIdentity(idTag)
+	if !exists {
+		return nil, fmt.Errorf("unknown id tag %v", idTag)
+	}
+	if period != QuotaPeriodMonthly && period != QuotaPeriodTotal {
+		return nil, fmt.Errorf("period must be %v or %v", QuotaPeriodMonthly, QuotaPeriodTotal)
+	}
+	if quotaWh < 0 {
+		return nil, fmt.Errorf("quota can't be negative")
+	}
+	auth.QuotaWh = quotaWh
+	auth.QuotaPeriod = period
+	storeIdentity(idTag, auth)
+	log.Printf("quota of %v set to %v Wh (%v)", idTag, quotaWh, period)
+	if err := saveIdentityFile(); err != nil {
+		return nil, err
+	}
+	return handler.GetQuota(idTag)
+}
+
+// remainingQuota is the smaller remaining energy of an identity and its parent account, ok is false without any quota
+func (handler *CentralSystemHandler) remainingQuota(idTag string) (remaining int64, ok bool) {
+	status, err := handler.GetQuota(idTag)
+	if err != nil {
+		return 0, false
+	}
+	for _, quota := range []*QuotaStatus{status, status.Parent} {
+		if quota == nil || quota.QuotaWh <= 0 {
+			continue
+		}
+		if !ok || quota.RemainingWh < remaining {
+			remaining = quota.RemainingWh
+		}
+		ok = true
+	}
+	return remaining, ok
+}
+
+func (handler *CentralSystemHandler) quotastart() {
+	ticker := time.NewTicker(quotainterval * time.Second)
+	go func() {
+		for range ticker.C {
+			handler.enforceQuotas()
+		}
+	}()
+}
+
+// enforceQuotas caps sessions close to their quota at quotareducecurrent and stops them once it is used up
+func (handler *CentralSystemHandler) enforceQuotas() {
+	for name, cp := range handler.ChargePoints {
+		for _, connector := range cp.Connectors {
+			transaction, ok := handler.Transactions[connector.CurrentTransaction]
+			if !connector.hasTransactionInProgress() || !ok || transaction.QuotaStopped {
+				continue
+			}
+			remaining, limited := handler.remainingQuota(transaction.IdTag)
+			if !limited {
+				continue
+			}
+			if remaining <= 0 {
+				transaction.QuotaStopped = true
+				logDefault(name, core.RemoteStopTransactionFeatureName).Infof("quota of %v used up, stopping transaction %v", transaction.IdTag, transaction.Id)
+				go handler.remoteStopTransaction(name, transaction.Id)
+			} else if remaining < quotareducebelowwh && connector.QuotaCap == 0 {
+				log.Printf("%v has %v Wh quota left, reducing transaction %v to %v A", transaction.IdTag, remaining, transaction.Id, quotareducecurrent)
+				connector.QuotaCap = quotareducecurrent
+				if connector.CurrentTargeted.L1 > quotareducecurrent {
+					connector.setTargeted(quotareducecurrent)
+					if group, ok := handler.Groups[cp.DLMGroup]; ok {
+						group.DLMActionPending = true
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/core"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/remotetrigger"
+)
+
+// ChargerRegistration records the boot data of a charge point and whether an operator approved it
+type ChargerRegistration struct {
+	Status          core.RegistrationStatus `json:"status"`
+	Vendor          string                  `json:"vendor"`
+	Model           string                  `json:"model"`
+	SerialNumber    string                  `json:"serial_number"`
+	FirmwareVersion string                  `json:"firmware_version"`
+	FirstSeen       time.Time               `json:"first_seen"`
+	LastBoot        time.Time               `json:"last_boot"`
+}
+
+// RegistrationPolicy lists vendors and models that are rejected on boot, compared case-insensitive
+type RegistrationPolicy struct {
+	DisallowedVendors []string `json:"disallowed_vendors"`
+	DisallowedModels  []string `json:"disallowed_models"`
+}
+
+func containsFold(list []string, value string) bool {
+	for _, entry := range list {
+		if strings.EqualFold(entry, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *RegistrationPolicy) allows(vendor string, model string) bool {
+	return !containsFold(policy.DisallowedVendors, vendor) && !containsFold(policy.DisallowedModels, model)
+}
+
+// registerBoot stores the boot data of a charge point and decides on the registration status.
+// Unknown charge points stay Pending until approved, charge points from before the registry existed are accepted.
+func (handler *CentralSystemHandler) registerBoot(chargePointID string, request *core.BootNotificationRequest) *ChargerRegistration {
+	reg, exists := handler.Registrations[chargePointID]
+	if !exists {
+		reg = &ChargerRegistration{Status: core.RegistrationStatusPending, FirstSeen: time.Now()}
+		if handler.ChargePointsInitialized[chargePointID] {
+			reg.Status = core.RegistrationStatusAccepted
+		}
+		handler.Registrations[chargePointID] = reg
+	}
+	reg.Vendor = request.ChargePointVendor
+	reg.Model = request.ChargePointModel
+	reg.SerialNumber = request.ChargePointSerialNumber
+	reg.FirmwareVersion = request.FirmwareVersion
+	reg.LastBoot = time.Now()
+	if !handler.RegistrationPolicy.allows(reg.Vendor, reg.Model) {
+		reg.Status = core.RegistrationStatusRejected
+	}
+	return reg
+}
+
+func (handler *CentralSystemHandler) isRegistrationAccepted(chargePointID string) bool {
+	reg, exists := handler.Registrations[chargePointID]
+	return exists && reg.Status == core.RegistrationStatusAccepted
+}
+
+// servesRequests tells if the requests of a charge point are served, Pending and Rejected ones are refused.
+// Charge points that didn't boot since the registry exists count as accepted, like in registerBoot.
+func (handler *CentralSystemHandler) servesRequests(chargePointID string) bool {
+	if _, exists := handler.Registrations[chargePointID]; !exists {
+		return handler.ChargePointsInitialized[chargePointID]
+	}
+	return handler.isRegistrationAccepted(chargePointID)
+}
+
+// startSetupRoutine runs setupRoutine once per connection
+func (handler *CentralSystemHandler) startSetupRoutine(chargePointID string) {
+	if handler.setupStarted[chargePointID] {
+		return
+	}
+	handler.setupStarted[chargePointID] = true
+	go setupRoutine(chargePointID, handler)
+}
+
+// GetRegistrations Http-RPC
+func (handler *CentralSystemHandler) GetRegistrations() map[string]*ChargerRegistration {
+	return handler.Registrations
+}
+
+// ApproveChargePoint Http-RPC, accepts a pending charge point and asks it to boot again so it gets Accepted
+func (handler *CentralSystemHandler) ApproveChargePoint(chargePointID string) error {
+	reg, exists := handler.Registrations[chargePointID]
+	if !exists {
+		return fmt.Errorf("charge point %v never booted", chargePointID)
+	}
+	if !handler.RegistrationPolicy.allows(reg.Vendor, reg.Model) {
+		return fmt.Errorf("vendor %v / model %v of %v is disallowed", reg.Vendor, reg.Model, chargePointID)
+	}
+	reg.Status = core.RegistrationStatusAccepted
+	log.WithField("client", chargePointID).Info("charge point registration approved")
+	callback := func(confirmation *remotetrigger.TriggerMessageConfirmation, err error) {
+		if err != nil {
+			logDefault(chargePointID, remotetrigger.TriggerMessageFeatureName).Errorf("error on request: %v", err)
+		} else {
+			logDefault(chargePointID, confirmation.GetFeatureName()).Infof("%v trigger %v", core.BootNotificationFeatureName, confirmation.Status)
+		}
+	}
+	e := centralSystem.TriggerMessage(chargePointID, callback, core.BootNotificationFeatureName)
+	if e != nil {
+		logDefault(chargePointID, remotetrigger.TriggerMessageFeatureName).Infof("couldn't trigger boot, waiting for next boot: %v", e)
+	}
+	return nil
+}
+
+// RejectChargePoint Http-RPC
+func (handler *CentralSystemHandler) RejectChargePoint(chargePointID string) error {
+	reg, exists := handler.Registrations[chargePointID]
+	if !exists {
+		return fmt.Errorf("charge point %v never booted", chargePointID)
+	}
+	reg.Status = core.RegistrationStatusRejected
+	log.WithField("client", chargePointID).Info("charge point registration rejected")
+	return nil
+}
+
+// SetRegistrationPolicy Http-RPC, takes comma separated lists of disallowed vendors and models
+func (handler *CentralSystemHandler) SetRegistrationPolicy(vendors string, models string) RegistrationPolicy {
+	handler.RegistrationPolicy.DisallowedVendors = splitList(vendors)
+	handler.RegistrationPolicy.DisallowedModels = splitList(models)
+	return handler.RegistrationPolicy
+}
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/reservation"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+const (
+	ReservationStatusActive    = "Active"
+	ReservationStatusConsumed  = "Consumed"
+	ReservationStatusCancelled = "Cancelled"
+	ReservationStatusExpired   = "Expired"
+)
+
+// Reservation of a connector for an id tag, connector 0 reserves any connector of the charge point
+type Reservation struct {
+	Id            int             `json:"id"`
+	ChargePointID string          `json:"charge_point_id"`
+	ConnectorId   int             `json:"connector_id"`
+	IdTag         string          `json:"id_tag"`
+	ExpiryDate    *types.DateTime `json:"expiry_date"`
+	Status        string          `json:"status"`
+	TransactionId int             `json:"transaction_id"`
+	Created       *types.DateTime `json:"created"`
+}
+
+// endReservation frees the connector the reservation was shown on
+func (handler *CentralSystemHandler) endReservation(res *Reservation, status string) {
+	res.Status = status
+	if cp, ok := handler.ChargePoints[res.ChargePointID]; ok {
+		if connector, ok := cp.Connectors[res.ConnectorId]; ok && connector.ReservationId == res.Id {
+			connector.ReservationId = 0
+			connector.ReservedIdTag = ""
+			connector.ReservedUntil = nil
+		}
+	}
+	logDefault(res.ChargePointID, reservation.ReserveNowFeatureName).Infof("reservation %v %v", res.Id, status)
+}
+
+// ReserveNow Http-RPC
+func (handler *CentralSystemHandler) ReserveNow(chargePointID string, connectorID int, idTag string, minutes int) (*Reservation, error) {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return nil, err
+	}
+	if minutes < 1 {
+		return nil, fmt.Errorf("reservation must last at least 1 minute")
+	}
+	for _, res := range handler.Reservations {
+		if res.Status == ReservationStatusActive && res.ChargePointID == chargePointID && res.ConnectorId == connectorID {
+			return nil, fmt.Errorf("connector %v already reserved (reservation %v)", connectorID, res.Id)
+		}
+	}
+	handler.NextReservationID++
+	res := &Reservation{
+		Id:            handler.NextReservationID,
+		ChargePointID: chargePointID,
+		ConnectorId:   connectorID,
+		IdTag:         idTag,
+		ExpiryDate:    types.NewDateTime(time.Now().Add(time.Duration(minutes) * time.Minute)),
+		Status:        ReservationStatusActive,
+		Created:       types.NewDateTime(time.Now()),
+	}
+	request := reservation.NewReserveNowRequest(connectorID, res.ExpiryDate, idTag, res.Id)
+	response, err := handler.sendRequestSync(chargePointID, request)
+	if err != nil {
+		logDefault(chargePointID, request.GetFeatureName()).Errorf("error on request: %v", err)
+		return nil, err
+	}
+	if status := response.(*reservation.ReserveNowConfirmation).Status; status != reservation.ReservationStatusAccepted {
+		return nil, fmt.Errorf("reservation not accepted: %v", status)
+	}
+	handler.Reservations[res.Id] = res
+	if connectorID > 0 {
+		connector := cp.getConnector(connectorID)
+		connector.ReservationId = res.Id
+		connector.ReservedIdTag = idTag
+		connector.ReservedUntil = res.ExpiryDate
+	}
+	logDefault(chargePointID, request.GetFeatureName()).Infof("connector %v reserved for %v until %v (reservation %v)", connectorID, idTag, res.ExpiryDate.FormatTimestamp(), res.Id)
+	return res, nil
+}
+
+// CancelReservation Http-RPC
+func (handler *CentralSystemHandler) CancelReservation(reservationID int) (*Reservation, error) {
+	res, ok := handler.Reservations[reservationID]
+	if !ok || res.Status != ReservationStatusActive {
+		return nil, fmt.Errorf("no active reservation %v", reservationID)
+	}
+	request := reservation.NewCancelReservationRequest(reservationID)
+	response, err := handler.sendRequestSync(res.ChargePointID, request)
+	if err != nil {
+		logDefault(res.ChargePointID, request.GetFeatureName()).Errorf("error on request: %v", err)
+		return nil, err
+	}
+	if status := response.(*reservation.CancelReservationConfirmation).Status; status != reservation.CancelReservationStatusAccepted {
+		return nil, fmt.Errorf("cancellation not accepted: %v", status)
+	}
+	handler.endReservation(res, ReservationStatusCancelled)
+	return res, nil
+}
+
+// GetReservations Http-RPC, optionally only the ones of a charge point or id tag
+func (handler *CentralSystemHandler) GetReservations(chargePointID string, idTag string) []*Reservation {
+	handler.expireReservations()
+	list := []*Reservation{}
+	for _, res := range handler.Reservations {
+		if (chargePointID == "" || res.ChargePointID == chargePointID) && (idTag == "" || res.IdTag == idTag) {
+			list = append(list, res)
+		}
+	}
+	return list
+}
+
+func (handler *CentralSystemHandler) expireReservations() {
+	for _, res := range handler.Reservations {
+		if res.Status == ReservationStatusActive && res.ExpiryDate.Before(time.Now()) {
+			handler.endReservation(res, ReservationStatusExpired)
+		}
+	}
+}
+
+func (handler *CentralSystemHandler) reservationstart() {
+	ticker := time.NewTicker(reservationinterval * time.Second)
+	go func() {
+		for range ticker.C {
+			handler.expireReservations()
+		}
+	}()
+}
+
+// consumeReservation marks the reservation used by a StartTransaction of its id tag or an id tag of its account
+func (handler *CentralSystemHandler) consumeReservation(chargePointID string, reservationID int, transactionID int, idTag string) {
+	res, ok := handler.Reservations[reservationID]
+	if !ok || res.ChargePointID != chargePointID {
+		logDefault(chargePointID, reservation.ReserveNowFeatureName).Warnf("transaction %v started with unknown reservation %v", transactionID, reservationID)
+		return
+	}
+	if res.Status != ReservationStatusActive {
+		logDefault(chargePointID, reservation.ReserveNowFeatureName).Warnf("transaction %v started with %v reservation %v", transactionID, res.Status, reservationID)
+		return
+	}
+	auth, _ := lookupIdentity(idTag)
+	if idTag != res.IdTag && (auth.ParentIdTag == "" || auth.ParentIdTag != res.IdTag) {
+		logDefault(chargePointID, reservation.ReserveNowFeatureName).Warnf("transaction %v of %v started with reservation %v of %v", transactionID, idTag, reservationID, res.IdTag)
+		return
+	}
+	res.TransactionId = transactionID
+	handler.endReservation(res, ReservationStatusConsumed)
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
 )
 
 type jsonreq struct {
@@ -27,7 +28,14 @@ func (handler *CentralSystemHandler) Listen(version string) {
 	log.Printf("Starting API SERVER")
 	handler.version = version
 	m := mux.NewRouter()
-	m.HandleFunc("/api", handler.api)
+	m.HandleFunc("/api", handler.apiAuth(handler.api))
+	handler.ocpiRoutes(m.PathPrefix("/ocpi").Subrouter())
+	m.HandleFunc("/files/firmware/{name}", handler.apiAuth(handler.firmwareUpload)).Methods("PUT", "POST")
+	m.HandleFunc("/files/diagnostics/{chargepoint}/", handler.diagnosticsUpload).Methods("PUT", "POST")
+	m.HandleFunc("/files/diagnostics/{chargepoint}/{name}", handler.diagnosticsUpload).Methods("PUT", "POST")
+	// diagnostics hold logs and configuration of the chargers, only API clients get them
+	m.PathPrefix("/files/diagnostics/").Handler(handler.apiAuth(http.StripPrefix("/files/diagnostics/", http.FileServer(http.Dir(diagnosticsdir))).ServeHTTP)).Methods("GET", "HEAD")
+	m.PathPrefix("/files/firmware/").Handler(http.StripPrefix("/files/firmware/", http.FileServer(http.Dir(firmwaredir)))).Methods("GET", "HEAD")
 	m.HandleFunc("/", handler.error)
 	log.Printf("Listening on Port 8080 on all interfaces")
 	err := http.ListenAndServe("0.0.0.0:8080", m)
@@ -50,6 +58,39 @@ func (handler *CentralSystemHandler) error(w http.ResponseWriter, r *http.Reques
 	w.Header().Set("Server", fullstring)
 }
 
+// rpcResult returns the error message as result if the call failed
+func rpcResult(result interface{}, err error) interface{} {
+	if err != nil {
+		return err.Error()
+	}
+	return result
+}
+
+// parseDateTimeParam parses an RFC3339 timestamp given as RPC param
+func parseDateTimeParam(value string) (*types.DateTime, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %v, use RFC3339", value)
+	}
+	return types.NewDateTime(t), nil
+}
+
+// parseRangeParams parses optional from and to params, empty or missing ones are left open
+func parseRangeParams(params []string) (from time.Time, to time.Time, err error) {
+	var bounds [2]time.Time
+	for i, param := range params {
+		if param == "" || i > 1 {
+			continue
+		}
+		bound, err := parseDateTimeParam(param)
+		if err != nil {
+			return from, to, err
+		}
+		bounds[i] = bound.Time
+	}
+	return bounds[0], bounds[1], nil
+}
+
 func (handler *CentralSystemHandler) api(w http.ResponseWriter, r *http.Request) {
 	var reply jsonreply
 	// START
@@ -66,6 +107,7 @@ func (handler *CentralSystemHandler) api(w http.ResponseWriter, r *http.Request)
 		reply.Result = statelist
 	case "remoteStartTransaction":
 		var idtag string
+		var connectorID int
 		chargePointID := req.Params[0]
 		if len(req.Params) > 1 {
 			idtag = req.Params[1]
@@ -73,15 +115,21 @@ func (handler *CentralSystemHandler) api(w http.ResponseWriter, r *http.Request)
 		} else {
 			idtag = "remoteStartNoIDSet"
 		}
+		if len(req.Params) > 2 {
+			connectorID, _ = strconv.Atoi(req.Params[2])
+		}
 		reply.Result = "true"
-		handler.SetChargePointRemoteStart(chargePointID, idtag)
+		handler.SetChargePointRemoteStart(chargePointID, idtag, connectorID)
 	case "remoteStopTransaction":
-		if len(req.Params) == 1 {
+		if len(req.Params) == 1 || len(req.Params) == 2 {
+			var connectorID int
 			chargePointID := req.Params[0]
-			reply.Result = "true"
-			handler.SetChargePointRemoteStop(chargePointID)
+			if len(req.Params) == 2 {
+				connectorID, _ = strconv.Atoi(req.Params[1])
+			}
+			reply.Result = strconv.FormatBool(handler.SetChargePointRemoteStop(chargePointID, connectorID))
 		} else {
-			reply.Result = "Need exactly 1 argument"
+			reply.Result = "Need 1 or 2 arguments (chargePointID, connectorID)"
 		}
 
 	case "unlockConnector":
@@ -113,13 +161,598 @@ func (handler *CentralSystemHandler) api(w http.ResponseWriter, r *http.Request)
 	case "overridePowerTarget":
 		var chargePointID string
 		var powerLimit string
-		if len(req.Params) == 2 {
+		var connectorID int
+		if len(req.Params) == 2 || len(req.Params) == 3 {
 			chargePointID = req.Params[0]
 			powerLimit = req.Params[1]
-			result := handler.OverridePowerTarget(chargePointID, powerLimit)
+			if len(req.Params) == 3 {
+				connectorID, _ = strconv.Atoi(req.Params[2])
+			}
+			result := handler.OverridePowerTarget(chargePointID, powerLimit, connectorID)
 			reply.Result = result
 		} else {
-			reply.Result = "Need exactly 2 params of type string"
+			reply.Result = "Need 2 or 3 params of type string (chargePointID, limit, connectorID)"
+		}
+	case "provisionChargePoint":
+		if len(req.Params) == 1 || len(req.Params) == 2 {
+			var password string
+			if len(req.Params) == 2 {
+				password = req.Params[1]
+			}
+			password, err := handler.ProvisionChargePoint(req.Params[0], password)
+			if err != nil {
+				reply.Result = err.Error()
+			} else {
+				reply.Result = password
+			}
+		} else {
+			reply.Result = "Need 1 or 2 params (chargePointID, password)"
+		}
+	case "rotateChargePointPassword":
+		if len(req.Params) == 1 {
+			password, err := handler.RotateChargePointPassword(req.Params[0])
+			if err != nil {
+				reply.Result = err.Error()
+			} else {
+				reply.Result = password
+			}
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "setChargePointAuthRequired":
+		if len(req.Params) == 1 {
+			required, err := strconv.ParseBool(req.Params[0])
+			if err == nil {
+				err = handler.SetChargePointAuthRequired(required)
+			}
+			if err != nil {
+				reply.Result = err.Error()
+			} else {
+				reply.Result = "true"
+			}
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "revokeChargePoint":
+		if len(req.Params) == 1 {
+			reply.Result = handler.RevokeChargePoint(req.Params[0])
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "getRegistrations":
+		reply.Result = handler.GetRegistrations()
+	case "approveChargePoint":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult("true", handler.ApproveChargePoint(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "rejectChargePoint":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult("true", handler.RejectChargePoint(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "setRegistrationPolicy":
+		if len(req.Params) == 2 {
+			reply.Result = handler.SetRegistrationPolicy(req.Params[0], req.Params[1])
+		} else {
+			reply.Result = "Need exactly 2 params (disallowed vendors, disallowed models), comma separated"
+		}
+	case "getInventory":
+		reply.Result = handler.GetInventory()
+	case "getConfiguration":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult(handler.GetConfigurationSnapshot(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "refreshConfiguration":
+		if len(req.Params) == 1 {
+			err := handler.RefreshConfiguration(req.Params[0])
+			if err == nil {
+				reply.Result = rpcResult(handler.GetConfigurationSnapshot(req.Params[0]))
+			} else {
+				reply.Result = err.Error()
+			}
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "getConfigurationDrift":
+		var model string
+		if len(req.Params) > 0 {
+			model = req.Params[0]
+		}
+		reply.Result = handler.GetConfigurationDrift(model)
+	case "getConfigProfiles":
+		reply.Result = handler.GetConfigProfiles()
+	case "setConfigProfile":
+		if len(req.Params) == 2 {
+			reply.Result = rpcResult("true", handler.SetConfigProfile(req.Params[0], req.Params[1]))
+		} else {
+			reply.Result = "Need exactly 2 params (name, settings as JSON object)"
+		}
+	case "deleteConfigProfile":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult("true", handler.DeleteConfigProfile(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "assignConfigProfile":
+		if len(req.Params) == 3 {
+			reply.Result = rpcResult("true", handler.AssignConfigProfile(req.Params[0], req.Params[1], req.Params[2]))
+		} else {
+			reply.Result = "Need exactly 3 params (group|chargepoint, id, profile name)"
+		}
+	case "getConfigurationResults":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult(handler.GetConfigurationResults(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "reset":
+		if len(req.Params) == 2 {
+			reply.Result = handler.Reset(req.Params[0], req.Params[1])
+		} else {
+			reply.Result = "Need exactly 2 params (chargePointID, Soft|Hard)"
+		}
+	case "resetGroup":
+		if len(req.Params) == 2 {
+			reply.Result = rpcResult(handler.groupOperation(req.Params[0], func(chargePointID string) OperationResult {
+				return handler.Reset(chargePointID, req.Params[1])
+			}))
+		} else {
+			reply.Result = "Need exactly 2 params (groupID, Soft|Hard)"
+		}
+	case "changeAvailability":
+		if len(req.Params) == 3 {
+			connectorID, err := strconv.Atoi(req.Params[1])
+			if err != nil {
+				reply.Result = "connectorID must be a number"
+			} else {
+				reply.Result = handler.ChangeAvailability(req.Params[0], connectorID, req.Params[2])
+			}
+		} else {
+			reply.Result = "Need exactly 3 params (chargePointID, connectorID, Operative|Inoperative)"
+		}
+	case "changeAvailabilityGroup":
+		if len(req.Params) == 3 {
+			connectorID, err := strconv.Atoi(req.Params[1])
+			if err != nil {
+				reply.Result = "connectorID must be a number"
+			} else {
+				reply.Result = rpcResult(handler.groupOperation(req.Params[0], func(chargePointID string) OperationResult {
+					return handler.ChangeAvailability(chargePointID, connectorID, req.Params[2])
+				}))
+			}
+		} else {
+			reply.Result = "Need exactly 3 params (groupID, connectorID, Operative|Inoperative)"
+		}
+	case "clearCache":
+		if len(req.Params) == 1 {
+			reply.Result = handler.ClearCache(req.Params[0])
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "clearCacheGroup":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult(handler.groupOperation(req.Params[0], handler.ClearCache))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "getConfigurationKeys":
+		if len(req.Params) >= 1 {
+			reply.Result = handler.GetConfigurationKeys(req.Params[0], req.Params[1:])
+		} else {
+			reply.Result = "Need at least 1 param (chargePointID, keys...)"
+		}
+	case "getConfigurationKeysGroup":
+		if len(req.Params) >= 1 {
+			reply.Result = rpcResult(handler.groupOperation(req.Params[0], func(chargePointID string) OperationResult {
+				return handler.GetConfigurationKeys(chargePointID, req.Params[1:])
+			}))
+		} else {
+			reply.Result = "Need at least 1 param (groupID, keys...)"
+		}
+	case "triggerMessage", "triggerMessageGroup":
+		if len(req.Params) == 2 || len(req.Params) == 3 {
+			connectorID := 0
+			if len(req.Params) == 3 {
+				connectorID, _ = strconv.Atoi(req.Params[2])
+			}
+			trigger := func(chargePointID string) OperationResult {
+				return handler.TriggerMessage(chargePointID, req.Params[1], connectorID)
+			}
+			if req.Method == "triggerMessageGroup" {
+				reply.Result = rpcResult(handler.groupOperation(req.Params[0], trigger))
+			} else {
+				reply.Result = trigger(req.Params[0])
+			}
+		} else {
+			reply.Result = "Need 2 or 3 params (chargePointID|groupID, message, connectorID)"
+		}
+	case "setFileServerURL":
+		if len(req.Params) == 1 {
+			reply.Result = handler.SetFileServerURL(req.Params[0])
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "setAPIToken":
+		if len(req.Params) == 1 {
+			reply.Result = handler.SetAPIToken(req.Params[0])
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "createFirmwareCampaign":
+		if len(req.Params) == 4 {
+			canarySize, err1 := strconv.Atoi(req.Params[2])
+			batchSize, err2 := strconv.Atoi(req.Params[3])
+			if err1 != nil || err2 != nil {
+				reply.Result = "canary and batch size must be numbers"
+			} else {
+				reply.Result = rpcResult(handler.CreateFirmwareCampaign(req.Params[0], splitList(req.Params[1]), canarySize, batchSize))
+			}
+		} else {
+			reply.Result = "Need exactly 4 params (image, comma separated chargePointIDs, canary size, batch size)"
+		}
+	case "getFirmwareCampaigns":
+		reply.Result = handler.GetFirmwareCampaigns()
+	case "pauseFirmwareCampaign", "resumeFirmwareCampaign", "cancelFirmwareCampaign":
+		states := map[string]string{"pauseFirmwareCampaign": CampaignStatePaused, "resumeFirmwareCampaign": CampaignStateRunning, "cancelFirmwareCampaign": CampaignStateCancelled}
+		if len(req.Params) == 1 {
+			id, err := strconv.Atoi(req.Params[0])
+			if err != nil {
+				reply.Result = "campaign id must be a number"
+			} else {
+				reply.Result = rpcResult(handler.SetFirmwareCampaignState(id, states[req.Method]))
+			}
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "requestDiagnostics":
+		if len(req.Params) >= 1 && len(req.Params) <= 3 {
+			var startTime, stopTime *types.DateTime
+			var err error
+			if len(req.Params) > 1 && req.Params[1] != "" {
+				startTime, err = parseDateTimeParam(req.Params[1])
+			}
+			if err == nil && len(req.Params) > 2 && req.Params[2] != "" {
+				stopTime, err = parseDateTimeParam(req.Params[2])
+			}
+			if err != nil {
+				reply.Result = err.Error()
+			} else {
+				reply.Result = rpcResult(handler.RequestDiagnostics(req.Params[0], startTime, stopTime))
+			}
+		} else {
+			reply.Result = "Need 1 to 3 params (chargePointID, start time, stop time as RFC3339)"
+		}
+	case "getDiagnostics":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult(handler.GetDiagnosticsList(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "reserveNow":
+		if len(req.Params) == 4 {
+			connectorID, err1 := strconv.Atoi(req.Params[1])
+			minutes, err2 := strconv.Atoi(req.Params[3])
+			if err1 != nil || err2 != nil {
+				reply.Result = "connectorID and minutes must be numbers"
+			} else {
+				reply.Result = rpcResult(handler.ReserveNow(req.Params[0], connectorID, req.Params[2], minutes))
+			}
+		} else {
+			reply.Result = "Need exactly 4 params (chargePointID, connectorID, idTag, minutes)"
+		}
+	case "cancelReservation":
+		if len(req.Params) == 1 {
+			reservationID, err := strconv.Atoi(req.Params[0])
+			if err != nil {
+				reply.Result = "reservationID must be a number"
+			} else {
+				reply.Result = rpcResult(handler.CancelReservation(reservationID))
+			}
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "getReservations":
+		var chargePointID, idTag string
+		if len(req.Params) > 0 {
+			chargePointID = req.Params[0]
+		}
+		if len(req.Params) > 1 {
+			idTag = req.Params[1]
+		}
+		reply.Result = handler.GetReservations(chargePointID, idTag)
+	case "syncLocalList":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult("true", handler.SyncLocalList(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "getLocalListStatus":
+		reply.Result = handler.GetLocalListStatus()
+	case "reloadIdentities":
+		reply.Result = rpcResult("true", handler.ReloadIdentities())
+	case "addIdTag", "updateIdTag":
+		if len(req.Params) >= 1 && len(req.Params) <= 5 {
+			details, err := parseIdentityDetails(req.Params[1:])
+			if err != nil {
+				reply.Result = err.Error()
+			} else if req.Method == "addIdTag" {
+				reply.Result = rpcResult(handler.AddIdTag(req.Params[0], details))
+			} else {
+				reply.Result = rpcResult(handler.UpdateIdTag(req.Params[0], details))
+			}
+		} else {
+			reply.Result = "Need 1 to 5 params (idTag, label, owner, expiry date as RFC3339, parent idTag)"
+		}
+	case "blockIdTag", "unblockIdTag":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult("true", handler.SetIdTagAuthorized(req.Params[0], req.Method == "unblockIdTag"))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "deleteIdTag":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult("true", handler.DeleteIdTag(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "getIdTags":
+		var kind string
+		if len(req.Params) > 0 {
+			kind = req.Params[0]
+		}
+		reply.Result = handler.GetIdTags(kind)
+	case "startPairing":
+		if len(req.Params) == 2 {
+			minutes, err := strconv.Atoi(req.Params[1])
+			if err != nil {
+				reply.Result = "minutes must be a number"
+			} else {
+				reply.Result = rpcResult(handler.StartPairing(req.Params[0], minutes))
+			}
+		} else {
+			reply.Result = "Need exactly 2 params (chargePointID, minutes)"
+		}
+	case "cancelPairing":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult("true", handler.CancelPairing(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "getPairings":
+		reply.Result = handler.GetPairings()
+	case "setFreeVend":
+		if len(req.Params) == 3 {
+			enabled, err := strconv.ParseBool(req.Params[2])
+			if err != nil {
+				reply.Result = "enabled must be true or false"
+			} else {
+				reply.Result = rpcResult("true", handler.SetFreeVend(req.Params[0], req.Params[1], enabled))
+			}
+		} else {
+			reply.Result = "Need exactly 3 params (group|chargepoint, id, true|false)"
+		}
+	case "getFlaggedTransactions":
+		reply.Result = handler.GetFlaggedTransactions()
+	case "setQuota":
+		if len(req.Params) == 3 {
+			quotaWh, err := strconv.ParseInt(req.Params[1], 10, 64)
+			if err != nil {
+				reply.Result = "quota must be a number in Wh"
+			} else {
+				reply.Result = rpcResult(handler.SetQuota(req.Params[0], quotaWh, req.Params[2]))
+			}
+		} else {
+			reply.Result = "Need exactly 3 params (idTag, quota in Wh, monthly|total)"
+		}
+	case "getQuota":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult(handler.GetQuota(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "getTransactions":
+		if len(req.Params) < 1 || len(req.Params) > 3 {
+			reply.Result = "Need 1 to 3 arguments"
+		} else if from, to, err := parseRangeParams(req.Params[1:]); err != nil {
+			reply.Result = err.Error()
+		} else {
+			reply.Result = rpcResult(handler.GetTransactions(req.Params[0], from, to))
+		}
+	case "setTariff":
+		if len(req.Params) == 2 {
+			reply.Result = rpcResult(handler.SetTariff(req.Params[0], req.Params[1]))
+		} else {
+			reply.Result = "Need exactly 2 arguments"
+		}
+	case "deleteTariff":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult("true", handler.DeleteTariff(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "getTariffs":
+		reply.Result = handler.Tariffs
+	case "assignTariff":
+		if len(req.Params) == 3 {
+			reply.Result = rpcResult("true", handler.AssignTariff(req.Params[0], req.Params[1], req.Params[2]))
+		} else {
+			reply.Result = "Need exactly 3 arguments"
+		}
+	case "createMissingCDRs":
+		reply.Result = handler.CreateMissingCDRs()
+	case "getCDRs":
+		var idTag string
+		var rangeParams []string
+		if len(req.Params) > 0 {
+			idTag, rangeParams = req.Params[0], req.Params[1:]
+		}
+		if len(req.Params) > 3 {
+			reply.Result = "Need at most 3 arguments"
+		} else if from, to, err := parseRangeParams(rangeParams); err != nil {
+			reply.Result = err.Error()
+		} else {
+			reply.Result = handler.GetCDRs(idTag, from, to)
+		}
+	case "exportStatement":
+		if len(req.Params) == 3 || len(req.Params) == 4 {
+			account := len(req.Params) == 4 && req.Params[3] == "true"
+			reply.Result = rpcResult(handler.ExportStatement(req.Params[0], req.Params[1], req.Params[2], account))
+		} else {
+			reply.Result = "Need 3 or 4 params (idTag, period YYYY-MM, csv or json, include account true/false)"
+		}
+	case "setOCPIParty":
+		if len(req.Params) == 2 || len(req.Params) == 3 {
+			var baseURL string
+			if len(req.Params) == 3 {
+				baseURL = req.Params[2]
+			}
+			reply.Result = rpcResult("true", handler.SetOCPIParty(req.Params[0], req.Params[1], baseURL))
+		} else {
+			reply.Result = "Need 2 or 3 params (country code, party id, public base url)"
+		}
+	case "registerOCPIPartner":
+		if len(req.Params) == 5 {
+			reply.Result = rpcResult(handler.RegisterOCPIPartner(req.Params[0], req.Params[1], req.Params[2], req.Params[3], req.Params[4]))
+		} else {
+			reply.Result = "Need 5 params (name, country code, party id, partner URL, callback token)"
+		}
+	case "deleteOCPIPartner":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult("true", handler.DeleteOCPIPartner(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "getOCPIPartners":
+		reply.Result = handler.GetOCPIPartners()
+	case "setOCPILocation":
+		if len(req.Params) == 1 || len(req.Params) == 2 {
+			var location string
+			if len(req.Params) == 2 {
+				location = req.Params[1]
+			}
+			reply.Result = rpcResult("true", handler.SetOCPILocation(req.Params[0], location))
+		} else {
+			reply.Result = "Need 1 or 2 params (chargePointID, location json)"
+		}
+	case "getOCPITokens":
+		reply.Result = handler.OCPI.Tokens
+	case "ocpiMockSetup":
+		reply.Result = rpcResult(handler.OCPIMockSetup())
+	case "ocpiMockPushToken":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult(handler.OCPIMockPushToken(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "ocpiMockCommand":
+		if len(req.Params) > 0 {
+			reply.Result = rpcResult(handler.OCPIMockCommand(req.Params[0], req.Params[1:]))
+		} else {
+			reply.Result = "Need at least 1 argument"
+		}
+	case "ocpiMockGet":
+		if len(req.Params) == 1 || len(req.Params) == 2 {
+			var offset int
+			if len(req.Params) == 2 {
+				offset, _ = strconv.Atoi(req.Params[1])
+			}
+			reply.Result = rpcResult(handler.OCPIMockGet(req.Params[0], offset))
+		} else {
+			reply.Result = "Need 1 or 2 params (module, offset)"
+		}
+	case "ocpiMockResults":
+		reply.Result = rpcResult(handler.OCPIMockResults())
+	case "getIdleConnectors":
+		reply.Result = handler.GetIdleConnectors()
+	case "getEvents":
+		var afterID int
+		if len(req.Params) > 0 {
+			afterID, _ = strconv.Atoi(req.Params[0])
+		}
+		reply.Result = handler.GetEvents(afterID)
+	case "setEventWebhook":
+		if len(req.Params) == 1 {
+			reply.Result = handler.SetEventWebhook(req.Params[0])
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "getSessionCurve":
+		if len(req.Params) == 1 || len(req.Params) == 2 {
+			transactionID, err := strconv.Atoi(req.Params[0])
+			var maxPoints int
+			if err == nil && len(req.Params) == 2 {
+				maxPoints, err = strconv.Atoi(req.Params[1])
+			}
+			if err != nil {
+				reply.Result = "transaction id and max points must be numbers"
+			} else {
+				reply.Result = rpcResult(handler.GetSessionCurve(transactionID, maxPoints))
+			}
+		} else {
+			reply.Result = "Need 1 or 2 params (transaction id, max points)"
+		}
+	case "setTargetSoC":
+		if len(req.Params) == 2 {
+			target, err := strconv.Atoi(req.Params[1])
+			if err != nil {
+				reply.Result = "target SoC must be a number"
+			} else {
+				reply.Result = rpcResult("true", handler.SetTargetSoC(req.Params[0], target))
+			}
+		} else {
+			reply.Result = "Need exactly 2 arguments"
+		}
+	case "setMeterPublicKey":
+		if len(req.Params) == 2 {
+			reply.Result = rpcResult("true", handler.SetMeterPublicKey(req.Params[0], req.Params[1]))
+		} else {
+			reply.Result = "Need exactly 2 arguments"
+		}
+	case "verifySignedValues":
+		if len(req.Params) == 1 {
+			transactionID, err := strconv.Atoi(req.Params[0])
+			if err != nil {
+				reply.Result = "transaction id must be a number"
+			} else {
+				reply.Result = rpcResult(handler.VerifySignedValues(transactionID))
+			}
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "setPlausibilityRules":
+		if len(req.Params) == 2 {
+			reply.Result = rpcResult(handler.SetPlausibilityRules(req.Params[0], req.Params[1]))
+		} else {
+			reply.Result = "Need exactly 2 arguments"
+		}
+	case "getAnomalies":
+		var chargePointID string
+		if len(req.Params) > 0 {
+			chargePointID = req.Params[0]
+		}
+		reply.Result = rpcResult(handler.GetAnomalies(chargePointID))
+	case "clearAnomalies":
+		if len(req.Params) == 1 {
+			reply.Result = rpcResult("true", handler.ClearAnomalies(req.Params[0]))
+		} else {
+			reply.Result = "Need exactly 1 argument"
+		}
+	case "setConnectorLimit":
+		if len(req.Params) == 3 {
+			connectorID, err1 := strconv.Atoi(req.Params[1])
+			limit, err2 := strconv.Atoi(req.Params[2])
+			if err1 != nil || err2 != nil {
+				reply.Result = "connector and limit must be numbers"
+			} else {
+				reply.Result = rpcResult("true", handler.SetConnectorLimit(req.Params[0], connectorID, limit))
+			}
+		} else {
+			reply.Result = "Need exactly 3 arguments"
 		}
 	//more or less a debug method
 	case "savePersistence":
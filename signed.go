@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+const (
+	SignatureVerified    = "Verified"
+	SignatureInvalid     = "Invalid"
+	SignatureNoKey       = "NoKey"
+	SignatureUnsupported = "Unsupported"
+)
+
+// SignedValue is a signed meter reading kept verbatim as the charger sent it
+type SignedValue struct {
+	Timestamp   *types.DateTime `json:"timestamp"`
+	Context     string          `json:"context"`
+	Measurand   string          `json:"measurand"`
+	SignedData  string          `json:"signed_data"`
+	Verifier    string          `json:"verifier,omitempty"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	Reading     *SignedReading  `json:"reading,omitempty"`
+	Verified    *types.DateTime `json:"verified,omitempty"`
+	Transaction string          `json:"transaction,omitempty"`
+}
+
+// SignedReading is what a verifier found inside the signed data
+type SignedReading struct {
+	MeterSerial string  `json:"meter_serial"`
+	Time        string  `json:"time"`
+	Value       float64 `json:"value"`
+	Unit        string  `json:"unit"`
+	Transaction string  `json:"transaction"`
+}
+
+// MeterValueVerifier checks signed meter data of one format against a charger's public key
+type MeterValueVerifier interface {
+	Name() string
+	// Accepts tells if the signed data is in the verifier's format
+	Accepts(signedData string) bool
+	// Transaction reads the begin/end marker of the signed data without checking the signature
+	Transaction(signedData string) string
+	Verify(signedData string, publicKey string) (*SignedReading, error)
+}
+
+// ErrUnsupportedSignature is returned by verifiers for algorithms they can't check
+type ErrUnsupportedSignature struct {
+	Algorithm string
+}
+
+func (err ErrUnsupportedSignature) Error() string {
+	return "unsupported signature algorithm " + err.Algorithm
+}
+
+// meterValueVerifiers are tried in order, the first accepting the data verifies it
+var meterValueVerifiers = []MeterValueVerifier{ocmfVerifier{}}
+
+// decodeSignedData undoes the base64 encoding some chargers put around the signed data
+func decodeSignedData(value string) string {
+	for _, verifier := range meterValueVerifiers {
+		if verifier.Accepts(value) {
+			return value
+		}
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return string(decoded)
+	}
+	return value
+}
+
+// verifySignedValue checks a signed value with the registered public key of the charger
+func verifySignedValue(value *SignedValue, publicKey string) {
+	value.Status, value.Error, value.Reading, value.Verifier = SignatureUnsupported, "no verifier for this format", nil, ""
+	data := decodeSignedData(value.SignedData)
+	for _, verifier := range meterValueVerifiers {
+		if !verifier.Accepts(data) {
+			continue
+		}
+		value.Verifier = verifier.Name()
+		value.Transaction = verifier.Transaction(data)
+		if publicKey == "" {
+			value.Status, value.Error = SignatureNoKey, "no public key registered for the charger"
+			return
+		}
+		reading, err := verifier.Verify(data, publicKey)
+		value.Verified = types.NewDateTime(time.Now())
+		switch err.(type) {
+		case nil:
+			value.Status, value.Error, value.Reading = SignatureVerified, "", reading
+		case ErrUnsupportedSignature:
+			value.Status, value.Error = SignatureUnsupported, err.Error()
+		default:
+			value.Status, value.Error = SignatureInvalid, err.Error()
+		}
+		return
+	}
+}
+
+// storeSignedValues keeps the signed sampled values of a transaction and verifies them
+func (handler *CentralSystemHandler) storeSignedValues(chargePointID string, transactionID int, meterValues []types.MeterValue) {
+	transaction, ok := handler.Transactions[transactionID]
+	cp, known := handler.ChargePoints[chargePointID]
+	if !ok || !known {
+		return
+	}
+	for _, mv := range meterValues {
+		for _, sv := range mv.SampledValue {
+			if sv.Format != types.ValueFormatSignedData {
+				continue
+			}
+			value := &SignedValue{Timestamp: mv.Timestamp, Context: string(sv.Context), Measurand: string(sv.Measurand), SignedData: sv.Value}
+			verifySignedValue(value, cp.MeterPublicKey)
+			if value.Status != SignatureVerified {
+				log.WithField("client", chargePointID).Warnf("signed meter value of transaction %v: %v %v", transactionID, value.Status, value.Error)
+			}
+			transaction.SignedValues = append(transaction.SignedValues, value)
+		}
+	}
+}
+
+// signedStartStop picks the signed readings of transaction begin and end, by context or by the
+// transaction field inside the signed data, verified or not so the CDR keeps them for later checks
+func (transaction *TransactionInfo) signedStartStop() (start *SignedValue, stop *SignedValue) {
+	for _, value := range transaction.SignedValues {
+		begin := value.Context == string(types.ReadingContextTransactionBegin) || (value.Context == "" && value.Transaction == "B")
+		end := value.Context == string(types.ReadingContextTransactionEnd) || (value.Context == "" && value.Transaction == "E")
+		if begin && start == nil {
+			start = value
+		}
+		if end {
+			stop = value
+		}
+	}
+	return start, stop
+}
+
+// SetMeterPublicKey Http-RPC, the public key of the charger's meter as hex DER or PEM
+func (handler *CentralSystemHandler) SetMeterPublicKey(chargePointID string, publicKey string) error {
+	cp, err := handler.chargePointByID(chargePointID)
+	if err != nil {
+		return err
+	}
+	if publicKey != "" {
+		if _, err = parsePublicKey(publicKey); err != nil {
+			return err
+		}
+	}
+	cp.MeterPublicKey = publicKey
+	log.WithField("client", chargePointID).Info("meter public key updated")
+	return nil
+}
+
+// VerifySignedValues Http-RPC, verifies the signed values of a transaction again, e.g. after registering the key
+func (handler *CentralSystemHandler) VerifySignedValues(transactionID int) ([]*SignedValue, error) {
+	transaction, ok := handler.Transactions[transactionID]
+	if !ok {
+		return nil, fmt.Errorf("unknown transaction %v", transactionID)
+	}
+	cp, err := handler.chargePointByID(transaction.ChargePointID)
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range transaction.SignedValues {
+		verifySignedValue(value, cp.MeterPublicKey)
+	}
+	if cdr, ok := handler.CDRs[transactionID]; ok {
+		cdr.SignedStart, cdr.SignedStop = transaction.signedStartStop()
+	}
+	return transaction.SignedValues, nil
+}
+
+// parsePublicKey reads an ECDSA public key given as PEM or hex encoded DER
+func parsePublicKey(publicKey string) (*ecdsa.PublicKey, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(publicKey)); block != nil {
+		der = block.Bytes
+	} else {
+		var err error
+		if der, err = hex.DecodeString(strings.TrimSpace(publicKey)); err != nil {
+			return nil, fmt.Errorf("public key must be PEM or hex encoded DER")
+		}
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %v", err)
+	}
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key isn't an ECDSA key")
+	}
+	return ecdsaKey, nil
+}
+
+// ocmfVerifier checks the Open Charge Metering Format: OCMF|<payload json>|<signature json>
+type ocmfVerifier struct{}
+
+type ocmfReading struct {
+	TM string  `json:"TM"`
+	TX string  `json:"TX"`
+	RV float64 `json:"RV"`
+	RU string  `json:"RU"`
+}
+
+type ocmfPayload struct {
+	MS string        `json:"MS"`
+	RD []ocmfReading `json:"RD"`
+}
+
+type ocmfSignature struct {
+	SA string `json:"SA"`
+	SE string `json:"SE"`
+	SD string `json:"SD"`
+}
+
+func (ocmfVerifier) Name() string {
+	return "OCMF"
+}
+
+func (ocmfVerifier) Accepts(signedData string) bool {
+	return strings.HasPrefix(signedData, "OCMF|")
+}
+
+// parse splits OCMF data into the raw payload, the decoded payload and the signature
+func (ocmfVerifier) parse(signedData string) (string, *ocmfPayload, *ocmfSignature, error) {
+	parts := strings.SplitN(strings.TrimPrefix(signedData, "OCMF|"), "|", 2)
+	if len(parts) != 2 {
+		return "", nil, nil, fmt.Errorf("OCMF data needs payload and signature")
+	}
+	var payload ocmfPayload
+	if err := json.Unmarshal([]byte(parts[0]), &payload); err != nil {
+		return "", nil, nil, fmt.Errorf("invalid OCMF payload: %v", err)
+	}
+	var signature ocmfSignature
+	if err := json.Unmarshal([]byte(parts[1]), &signature); err != nil {
+		return "", nil, nil, fmt.Errorf("invalid OCMF signature: %v", err)
+	}
+	return parts[0], &payload, &signature, nil
+}
+
+func (verifier ocmfVerifier) Transaction(signedData string) string {
+	_, payload, _, err := verifier.parse(signedData)
+	if err != nil || len(payload.RD) == 0 {
+		return ""
+	}
+	return payload.RD[len(payload.RD)-1].TX
+}
+
+func (verifier ocmfVerifier) Verify(signedData string, publicKey string) (*SignedReading, error) {
+	raw, payload, signature, err := verifier.parse(signedData)
+	if err != nil {
+		return nil, err
+	}
+	if signature.SA == "" {
+		signature.SA = "ECDSA-secp256r1-SHA256"
+	}
+	var curve elliptic.Curve
+	var digest hash.Hash
+	switch signature.SA {
+	case "ECDSA-secp256r1-SHA256":
+		curve, digest = elliptic.P256(), sha256.New()
+	case "ECDSA-secp384r1-SHA256":
+		curve, digest = elliptic.P384(), sha256.New()
+	case "ECDSA-secp384r1-SHA384":
+		curve, digest = elliptic.P384(), sha512.New384()
+	default:
+		return nil, ErrUnsupportedSignature{signature.SA}
+	}
+	var sig []byte
+	switch signature.SE {
+	case "", "hex":
+		sig, err = hex.DecodeString(signature.SD)
+	case "base64":
+		sig, err = base64.StdEncoding.DecodeString(signature.SD)
+	default:
+		return nil, fmt.Errorf("unknown signature encoding %v", signature.SE)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if key.Curve != curve {
+		return nil, fmt.Errorf("public key doesn't match %v", signature.SA)
+	}
+	digest.Write([]byte(raw))
+	if !ecdsa.VerifyASN1(key, digest.Sum(nil), sig) {
+		return nil, fmt.Errorf("signature doesn't match")
+	}
+	reading := &SignedReading{MeterSerial: payload.MS}
+	if len(payload.RD) > 0 {
+		last := payload.RD[len(payload.RD)-1]
+		reading.Time, reading.Value, reading.Unit, reading.Transaction = last.TM, last.RV, last.RU, last.TX
+	}
+	return reading, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// signOCMF signs an OCMF payload with ECDSA-secp256r1-SHA256 as a meter would
+func signOCMF(t *testing.T, key *ecdsa.PrivateKey, payload string) string {
+	digest := sha256.Sum256([]byte(payload))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("can't sign: %v", err)
+	}
+	return "OCMF|" + payload + `|{"SA":"ECDSA-secp256r1-SHA256","SD":"` + hex.EncodeToString(sig) + `"}`
+}
+
+func publicKeyHex(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("can't marshal public key: %v", err)
+	}
+	return hex.EncodeToString(der)
+}
+
+func generateKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("can't generate key: %v", err)
+	}
+	return key
+}
+
+const ocmfPayloadBegin = `{"MS":"EMH-1234","RD":[{"TM":"2026-06-01T10:00:00,000+0000 S","TX":"B","RV":1.5,"RU":"kWh"}]}`
+
+func TestOCMFVerify(t *testing.T) {
+	key := generateKey(t, elliptic.P256())
+	signed := signOCMF(t, key, ocmfPayloadBegin)
+	tests := []struct {
+		name       string
+		signedData string
+		publicKey  string
+		wantStatus string
+	}{
+		{name: "valid", signedData: signed, publicKey: publicKeyHex(t, key), wantStatus: SignatureVerified},
+		{name: "tampered value", signedData: strings.Replace(signed, `"RV":1.5`, `"RV":0.5`, 1), publicKey: publicKeyHex(t, key), wantStatus: SignatureInvalid},
+		{name: "other key", signedData: signed, publicKey: publicKeyHex(t, generateKey(t, elliptic.P256())), wantStatus: SignatureInvalid},
+		{name: "wrong curve", signedData: signed, publicKey: publicKeyHex(t, generateKey(t, elliptic.P384())), wantStatus: SignatureInvalid},
+		{name: "unsupported algorithm", signedData: strings.Replace(signed, "ECDSA-secp256r1-SHA256", "RSA-SHA256", 1), publicKey: publicKeyHex(t, key), wantStatus: SignatureUnsupported},
+		{name: "no key", signedData: signed, wantStatus: SignatureNoKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := &SignedValue{SignedData: tt.signedData}
+			verifySignedValue(value, tt.publicKey)
+			if value.Status != tt.wantStatus {
+				t.Fatalf("got %v (%v), want %v", value.Status, value.Error, tt.wantStatus)
+			}
+			if tt.wantStatus == SignatureVerified && (value.Reading == nil || value.Reading.Value != 1.5 || value.Reading.MeterSerial != "EMH-1234") {
+				t.Fatalf("got reading %+v", value.Reading)
+			}
+			if tt.wantStatus != SignatureVerified && value.Reading != nil {
+				t.Fatalf("unverified value has reading %+v", value.Reading)
+			}
+		})
+	}
+}
+
+func TestSignedStartStopWithoutKey(t *testing.T) {
+	key := generateKey(t, elliptic.P256())
+	begin := &SignedValue{SignedData: signOCMF(t, key, ocmfPayloadBegin)}
+	end := &SignedValue{SignedData: signOCMF(t, key, strings.Replace(ocmfPayloadBegin, `"TX":"B"`, `"TX":"E"`, 1))}
+	transaction := &TransactionInfo{SignedValues: []*SignedValue{begin, end}}
+	for _, value := range transaction.SignedValues {
+		verifySignedValue(value, "")
+	}
+	start, stop := transaction.signedStartStop()
+	if start != begin || stop != end {
+		t.Fatalf("got start %+v and stop %+v", start, stop)
+	}
+	if start.Status != SignatureNoKey {
+		t.Fatalf("got status %v, want %v", start.Status, SignatureNoKey)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+// updateTransactionSoC takes the vehicle's SoC from meter values, the first one is kept as start SoC
+func (handler *CentralSystemHandler) updateTransactionSoC(chargePointID string, transactionID int, meterValues []types.MeterValue) {
+	transaction, ok := handler.Transactions[transactionID]
+	if !ok {
+		return
+	}
+	for _, mv := range meterValues {
+		for _, sv := range mv.SampledValue {
+			if sv.Measurand != "SoC" {
+				continue
+			}
+			reading, err := parseMeterValue(string(sv.Measurand), string(sv.Phase), string(sv.Unit), string(sv.Format), sv.Value)
+			if err != nil || reading.Signed {
+				continue
+			}
+			soc := reading.Value
+			if transaction.StartSoC == nil {
+				transaction.StartSoC = &soc
+			}
+			transaction.SoC = &soc
+			transaction.SoCUpdated = types.NewDateTime(time.Now())
+			if mv.Timestamp != nil {
+				transaction.SoCUpdated = mv.Timestamp
+			}
+		}
+	}
+	if transaction.SoC != nil && !transaction.hasTransactionEnded() {
+		handler.checkTargetSoC(chargePointID, transaction)
+	}
+}
+
+// targetSoCOf is the target SoC of an identity or else of its parent account, 0 for none
+func targetSoCOf(idTag string) int {
+	auth, exists := lookupIdentity(idTag)
+	if !exists {
+		return 0
+	}
+	if auth.TargetSoC == 0 && auth.ParentIdTag != "" {
+		if parent, exists := lookupIdentity(auth.ParentIdTag); exists {
+			return parent.TargetSoC
+		}
+	}
+	return auth.TargetSoC
+}
+
+// checkTargetSoC stops a transaction once the vehicle reached the target SoC of its identity
+func (handler *CentralSystemHandler) checkTargetSoC(chargePointID string, transaction *TransactionInfo) {
+	target := targetSoCOf(transaction.IdTag)
+	if target == 0 || transaction.TargetSoCStopped || *transaction.SoC < float64(target) {
+		return
+	}
+	transaction.TargetSoCStopped = true
+	log.WithField("client", chargePointID).Infof("transaction %v reached %v%% SoC, target of %v is %v%%", transaction.Id, *transaction.SoC, transaction.IdTag, target)
+	go handler.remoteStopTransaction(chargePointID, transaction.Id)
+}
+
+// hasHighSoC tells if the vehicle charging on a connector is above dlmlowprioritysoc
+func (handler *CentralSystemHandler) hasHighSoC(ref connectorRef) bool {
+	cp, ok := handler.ChargePoints[ref.ChargePoint]
+	if !ok {
+		return false
+	}
+	connector, ok := cp.Connectors[ref.Connector]
+	if !ok || !connector.hasTransactionInProgress() {
+		return false
+	}
+	transaction, ok := handler.Transactions[connector.CurrentTransaction]
+	return ok && transaction.SoC != nil && *transaction.SoC >= dlmlowprioritysoc
+}
+
+// socShares splits the available current of a group between connectors wanting full power. Vehicles above
+// dlmlowprioritysoc get at most dlmlowprioritycurrent, what they and connectors capped below dlmmaxcurrent
+// don't take is shared by the others.
+func (handler *CentralSystemHandler) socShares(chargers map[connectorRef]bool, available int) map[connectorRef]int {
+	caps := make(map[connectorRef]int, len(chargers))
+	refs := make([]connectorRef, 0, len(chargers))
+	for ref := range chargers {
+		caps[ref] = handler.ChargePoints[ref.ChargePoint].getConnector(ref.Connector).capCurrent(dlmmaxcurrent)
+		if handler.hasHighSoC(ref) && caps[ref] > dlmlowprioritycurrent {
+			caps[ref] = dlmlowprioritycurrent
+		}
+		refs = append(refs, ref)
+	}
+	// the most limited connectors first, the others split what is left
+	sort.Slice(refs, func(i, j int) bool {
+		if caps[refs[i]] != caps[refs[j]] {
+			return caps[refs[i]] < caps[refs[j]]
+		}
+		return refs[i].String() < refs[j].String()
+	})
+	shares := make(map[connectorRef]int, len(chargers))
+	for i, ref := range refs {
+		share := available / (len(refs) - i)
+		if share > caps[ref] {
+			share = caps[ref]
+		}
+		if share < 0 {
+			share = 0
+		}
+		shares[ref] = share
+		available -= share
+	}
+	return shares
+}
+
+// SetTargetSoC Http-RPC, transactions of the id tag are stopped at that SoC, 0 removes it
+func (handler *CentralSystemHandler) SetTargetSoC(idTag string, target int) error {
+	auth, exists := lookupIdentity(idTag)
+	if !exists {
+		return fmt.Errorf("unknown id tag %v", idTag)
+	}
+	if target < 0 || target > 100 {
+		return fmt.Errorf("target SoC must be 0 to 100")
+	}
+	auth.TargetSoC = target
+	storeIdentity(idTag, auth)
+	log.Printf("target SoC of %v set to %v%%", idTag, target)
+	return saveIdentityFile()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// StatementRow is one finished transaction of a statement
+type StatementRow struct {
+	TransactionId int       `json:"transaction_id"`
+	IdTag         string    `json:"id_tag"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	ChargePointID string    `json:"charge_point_id"`
+	ConnectorId   int       `json:"connector_id"`
+	StartMeter    int       `json:"start_meter"`
+	EndMeter      int       `json:"end_meter"`
+	EnergyKWh     float64   `json:"energy_kwh"`
+	Tariff        string    `json:"tariff"`
+	Currency      string    `json:"currency"`
+	Cost          float64   `json:"cost"`
+	SignedStart   string    `json:"signed_start,omitempty"`
+	SignedStop    string    `json:"signed_stop,omitempty"`
+	Signature     string    `json:"signature,omitempty"`
+}
+
+// Statement lists the finished transactions of an identity or parent account started within a billing period
+type Statement struct {
+	Account    string             `json:"account"`
+	Period     string             `json:"period"`
+	From       time.Time          `json:"from"`
+	To         time.Time          `json:"to"`
+	Rows       []StatementRow     `json:"rows"`
+	TotalKWh   float64            `json:"total_kwh"`
+	TotalCosts map[string]float64 `json:"total_costs"`
+	// MissingCDRs are transactions of the period without CDR, see createMissingCDRs
+	MissingCDRs []int `json:"missing_cdrs"`
+	// Flagged are transactions left out because their meter stop is below their meter start
+	Flagged []int `json:"flagged"`
+}
+
+// billingPeriod parses a month ("2006-01") into its local time bounds
+func billingPeriod(period string) (time.Time, time.Time, error) {
+	month, err := time.ParseInLocation("2006-01", period, time.Local)
+	if err != nil {
+		return month, month, fmt.Errorf("invalid period %v, use YYYY-MM", period)
+	}
+	return month, month.AddDate(0, 1, 0), nil
+}
+
+// BuildStatement collects the statement of an id tag, with account set the id tags having it as parent are included.
+// Rows are priced from the stored CDRs, so a statement doesn't change when tariffs do. Transactions without a
+// CDR are listed as missing.
+func (handler *CentralSystemHandler) BuildStatement(idTag string, period string, account bool) (*Statement, error) {
+	if _, exists := lookupIdentity(idTag); !exists {
+		return nil, fmt.Errorf("unknown id tag %v", idTag)
+	}
+	from, to, err := billingPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]bool{idTag: true}
+	if account {
+		tags = accountIdTags(idTag)
+	}
+	statement := &Statement{Account: idTag, Period: period, From: from, To: to, Rows: []StatementRow{}, TotalCosts: map[string]float64{}, MissingCDRs: []int{}, Flagged: []int{}}
+	for _, transaction := range handler.Transactions {
+		if !tags[transaction.IdTag] || !transaction.hasTransactionEnded() || transaction.StartTime == nil {
+			continue
+		}
+		if transaction.StartTime.Before(from) || !transaction.StartTime.Before(to) {
+			continue
+		}
+		if transaction.hasAnomaly(AnomalyMeterStopBelowStart) {
+			statement.Flagged = append(statement.Flagged, transaction.Id)
+			continue
+		}
+		cdr, ok := handler.CDRs[transaction.Id]
+		if !ok {
+			statement.MissingCDRs = append(statement.MissingCDRs, transaction.Id)
+			continue
+		}
+		row := StatementRow{
+			TransactionId: transaction.Id,
+			IdTag:         transaction.IdTag,
+			StartTime:     transaction.StartTime.Time,
+			EndTime:       transaction.EndTime.Time,
+			ChargePointID: transaction.ChargePointID,
+			ConnectorId:   transaction.ConnectorId,
+			StartMeter:    transaction.StartMeter,
+			EndMeter:      transaction.EndMeter,
+			EnergyKWh:     float64(cdr.EnergyWh) / 1000,
+			Tariff:        cdr.Tariff,
+			Currency:      cdr.Currency,
+			Cost:          cdr.Total,
+		}
+		row.SignedStart, row.SignedStop, row.Signature = signedColumns(cdr)
+		statement.Rows = append(statement.Rows, row)
+		statement.TotalKWh += row.EnergyKWh
+		statement.TotalCosts[row.Currency] = roundAmount(statement.TotalCosts[row.Currency] + row.Cost)
+	}
+	sort.Ints(statement.MissingCDRs)
+	sort.Ints(statement.Flagged)
+	sort.Slice(statement.Rows, func(i, j int) bool {
+		return statement.Rows[i].StartTime.Before(statement.Rows[j].StartTime)
+	})
+	statement.TotalKWh = math.Round(statement.TotalKWh*1000) / 1000
+	return statement, nil
+}
+
+// signedColumns are the signed start and stop readings of a CDR and the worst signature status of both
+func signedColumns(cdr *CDR) (start string, stop string, status string) {
+	for _, value := range []*SignedValue{cdr.SignedStart, cdr.SignedStop} {
+		if value == nil {
+			continue
+		}
+		if status == "" || status == SignatureVerified {
+			status = value.Status
+		}
+	}
+	if cdr.SignedStart != nil {
+		start = cdr.SignedStart.SignedData
+	}
+	if cdr.SignedStop != nil {
+		stop = cdr.SignedStop.SignedData
+	}
+	return start, stop, status
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// CSV renders the statement with one row per transaction followed by one total row per currency
+func (statement *Statement) CSV() ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	records := [][]string{{"transaction_id", "id_tag", "start_time", "end_time", "charge_point_id", "connector_id", "start_meter_wh", "end_meter_wh", "energy_kwh", "tariff", "currency", "cost", "signed_start", "signed_stop", "signature"}}
+	for _, row := range statement.Rows {
+		records = append(records, []string{
+			strconv.Itoa(row.TransactionId),
+			row.IdTag,
+			row.StartTime.Format(time.RFC3339),
+			row.EndTime.Format(time.RFC3339),
+			row.ChargePointID,
+			strconv.Itoa(row.ConnectorId),
+			strconv.Itoa(row.StartMeter),
+			strconv.Itoa(row.EndMeter),
+			strconv.FormatFloat(row.EnergyKWh, 'f', 3, 64),
+			row.Tariff,
+			row.Currency,
+			formatAmount(row.Cost),
+			row.SignedStart,
+			row.SignedStop,
+			row.Signature,
+		})
+	}
+	currencies := make([]string, 0, len(statement.TotalCosts))
+	for currency := range statement.TotalCosts {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	totalKWh := strconv.FormatFloat(statement.TotalKWh, 'f', 3, 64)
+	if len(currencies) == 0 {
+		records = append(records, []string{"total", statement.Account, "", "", "", "", "", "", totalKWh, "", "", formatAmount(0), "", "", ""})
+	}
+	for _, currency := range currencies {
+		records = append(records, []string{"total", statement.Account, "", "", "", "", "", "", totalKWh, "", currency, formatAmount(statement.TotalCosts[currency]), "", "", ""})
+	}
+	if err := writer.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// ExportStatement Http-RPC, format is "csv" or "json", the file is written to the exports directory as well
+func (handler *CentralSystemHandler) ExportStatement(idTag string, period string, format string, account bool) (string, error) {
+	statement, err := handler.BuildStatement(idTag, period, account)
+	if err != nil {
+		return "", err
+	}
+	var content []byte
+	switch format {
+	case "csv":
+		content, err = statement.CSV()
+	case "json":
+		content, err = json.MarshalIndent(statement, "", " ")
+	default:
+		return "", fmt.Errorf("unknown format %v, use csv or json", format)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(exportsdir, 0755); err != nil {
+		return "", err
+	}
+	name := idTag + "-" + period
+	if account {
+		name += "-account"
+	}
+	name, err = cleanFileName(name + "." + format)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(exportsdir, name)
+	if err = ioutil.WriteFile(file, content, 0644); err != nil {
+		return "", err
+	}
+	log.Printf("statement of %v for %v exported to %v", idTag, period, file)
+	return string(content), nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+const defaulttariff = "default"
+
+// TariffPeriod overrides the energy and time price of a tariff between two local times of day ("HH:MM"),
+// a period may wrap midnight
+type TariffPeriod struct {
+	Start       string  `json:"start"`
+	End         string  `json:"end"`
+	EnergyPrice float64 `json:"energy_price"`
+	TimePrice   float64 `json:"time_price"`
+}
+
+// Tariff prices a session, energy per kWh, time and idle fee per minute
+type Tariff struct {
+	Currency         string         `json:"currency"`
+	EnergyPrice      float64        `json:"energy_price"`
+	TimePrice        float64        `json:"time_price"`
+	SessionFee       float64        `json:"session_fee"`
+	IdleFee          float64        `json:"idle_fee"`
+	IdleGraceMinutes int            `json:"idle_grace_minutes"`
+	Periods          []TariffPeriod `json:"periods"`
+}
+
+// CDRLineItem is one priced position of a charge detail record
+type CDRLineItem struct {
+	Type        string  `json:"type"`
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	UnitPrice   float64 `json:"unit_price"`
+	Amount      float64 `json:"amount"`
+}
+
+// CDR is the priced charge detail record of a finished transaction
+type CDR struct {
+	TransactionId int             `json:"transaction_id"`
+	ChargePointID string          `json:"charge_point_id"`
+	ConnectorId   int             `json:"connector_id"`
+	IdTag         string          `json:"id_tag"`
+	StopIdTag     string          `json:"stop_id_tag,omitempty"`
+	StartTime     *types.DateTime `json:"start_time"`
+	EndTime       *types.DateTime `json:"end_time"`
+	IdleSince     *types.DateTime `json:"idle_since,omitempty"`
+	EnergyWh      int             `json:"energy_wh"`
+	Tariff        string          `json:"tariff"`
+	Currency      string          `json:"currency"`
+	LineItems     []CDRLineItem   `json:"line_items"`
+	Total         float64         `json:"total"`
+	SignedStart   *SignedValue    `json:"signed_start,omitempty"`
+	SignedStop    *SignedValue    `json:"signed_stop,omitempty"`
+	Anomalies     []string        `json:"anomalies,omitempty"`
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %v, use HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (tariff *Tariff) validate() error {
+	if tariff.EnergyPrice < 0 || tariff.TimePrice < 0 || tariff.SessionFee < 0 || tariff.IdleFee < 0 || tariff.IdleGraceMinutes < 0 {
+		return fmt.Errorf("prices can't be negative")
+	}
+	for _, period := range tariff.Periods {
+		start, err := parseTimeOfDay(period.Start)
+		if err != nil {
+			return err
+		}
+		end, err := parseTimeOfDay(period.End)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return fmt.Errorf("period %v-%v is empty", period.Start, period.End)
+		}
+		if period.EnergyPrice < 0 || period.TimePrice < 0 {
+			return fmt.Errorf("prices can't be negative")
+		}
+	}
+	return nil
+}
+
+// pricesAt returns the energy and time price at a point in time and when they change next. Periods are
+// wall clock times, so they are placed with time.Date to stay right on DST days.
+func (tariff *Tariff) pricesAt(t time.Time) (energyPrice float64, timePrice float64, description string, next time.Time) {
+	local := t.Local()
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	sinceMidnight := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute + time.Duration(local.Second())*time.Second + time.Duration(local.Nanosecond())
+	energyPrice, timePrice, description = tariff.EnergyPrice, tariff.TimePrice, "base"
+	next = midnight.AddDate(0, 0, 1)
+	found := false
+	for _, period := range tariff.Periods {
+		start, _ := parseTimeOfDay(period.Start)
+		end, _ := parseTimeOfDay(period.End)
+		active := sinceMidnight >= start && sinceMidnight < end
+		if start > end {
+			active = sinceMidnight >= start || sinceMidnight < end
+		}
+		if active && !found {
+			energyPrice, timePrice, description = period.EnergyPrice, period.TimePrice, period.Start+"-"+period.End
+			found = true
+		}
+		for _, boundary := range []time.Duration{start, end} {
+			at := time.Date(local.Year(), local.Month(), local.Day(), int(boundary/time.Hour), int(boundary%time.Hour/time.Minute), 0, 0, local.Location())
+			if at.After(local) && at.Before(next) {
+				next = at
+			}
+		}
+	}
+	return energyPrice, timePrice, description, next
+}
+
+// tariffOf picks the tariff of a transaction: identity, its parent account, the charger's group, then the default tariff
+func (handler *CentralSystemHandler) tariffOf(transaction *TransactionInfo) (string, *Tariff) {
+	var candidates []string
+	if auth, exists := lookupIdentity(transaction.IdTag); exists {
+		candidates = append(candidates, auth.Tariff)
+		if parent, exists := lookupIdentity(auth.ParentIdTag); auth.ParentIdTag != "" && exists {
+			candidates = append(candidates, parent.Tariff)
+		}
+	}
+	if cp, ok := handler.ChargePoints[transaction.ChargePointID]; ok {
+		if group, ok := handler.Groups[cp.DLMGroup]; ok {
+			candidates = append(candidates, group.Tariff)
+		}
+	}
+	candidates = append(candidates, defaulttariff)
+	for _, name := range candidates {
+		if tariff, ok := handler.Tariffs[name]; name != "" && ok {
+			return name, tariff
+		}
+	}
+	return "", nil
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// addLineItem merges items of the same type and description
+func (cdr *CDR) addLineItem(itemType string, description string, quantity float64, unit string, unitPrice float64) {
+	if quantity <= 0 {
+		return
+	}
+	for i := range cdr.LineItems {
+		item := &cdr.LineItems[i]
+		if item.Type == itemType && item.Description == description {
+			item.Quantity += quantity
+			return
+		}
+	}
+	cdr.LineItems = append(cdr.LineItems, CDRLineItem{Type: itemType, Description: description, Quantity: quantity, Unit: unit, UnitPrice: unitPrice})
+}
+
+// priceTransaction creates the CDR of a finished transaction. Without meter values per period the
+// energy is spread evenly over the time until the vehicle went idle.
+func priceTransaction(transaction *TransactionInfo, tariffName string, tariff *Tariff) *CDR {
+	cdr := &CDR{
+		TransactionId: transaction.Id,
+		ChargePointID: transaction.ChargePointID,
+		ConnectorId:   transaction.ConnectorId,
+		IdTag:         transaction.IdTag,
+		StopIdTag:     transaction.StopIdTag,
+		StartTime:     transaction.StartTime,
+		EndTime:       transaction.EndTime,
+		IdleSince:     transaction.IdleSince,
+		EnergyWh:      transaction.EndMeter - transaction.StartMeter,
+		Tariff:        tariffName,
+		LineItems:     []CDRLineItem{},
+		Anomalies:     transaction.Anomalies,
+	}
+	cdr.SignedStart, cdr.SignedStop = transaction.signedStartStop()
+	if cdr.EnergyWh < 0 {
+		cdr.EnergyWh = 0
+	}
+	if tariff == nil || transaction.StartTime == nil || transaction.EndTime == nil {
+		return cdr
+	}
+	cdr.Currency = tariff.Currency
+	start, end := transaction.StartTime.Time, transaction.EndTime.Time
+	chargingEnd := end
+	if transaction.IdleSince != nil && transaction.IdleSince.After(start) && transaction.IdleSince.Before(end) {
+		chargingEnd = transaction.IdleSince.Time
+	}
+	chargingDuration := chargingEnd.Sub(start)
+	// time is charged until the vehicle went idle, the idle time after that has its own fee
+	for t := start; t.Before(chargingEnd); {
+		energyPrice, timePrice, description, next := tariff.pricesAt(t)
+		if !next.After(t) {
+			break
+		}
+		if next.After(chargingEnd) {
+			next = chargingEnd
+		}
+		cdr.addLineItem("time", description, next.Sub(t).Minutes(), "min", timePrice)
+		kWh := float64(cdr.EnergyWh) / 1000 * float64(next.Sub(t)) / float64(chargingDuration)
+		cdr.addLineItem("energy", description, kWh, "kWh", energyPrice)
+		t = next
+	}
+	if chargingDuration <= 0 {
+		energyPrice, _, description, _ := tariff.pricesAt(start)
+		cdr.addLineItem("energy", description, float64(cdr.EnergyWh)/1000, "kWh", energyPrice)
+	}
+	if chargingEnd.Before(end) {
+		idleMinutes := end.Sub(chargingEnd).Minutes() - float64(tariff.IdleGraceMinutes)
+		cdr.addLineItem("idle", fmt.Sprintf("after %v min grace", tariff.IdleGraceMinutes), idleMinutes, "min", tariff.IdleFee)
+	}
+	cdr.addLineItem("session", "session fee", 1, "session", tariff.SessionFee)
+	items := cdr.LineItems[:0]
+	for _, item := range cdr.LineItems {
+		if item.UnitPrice == 0 {
+			continue
+		}
+		item.Quantity = math.Round(item.Quantity*1000) / 1000
+		item.Amount = roundAmount(item.Quantity * item.UnitPrice)
+		cdr.Total += item.Amount
+		items = append(items, item)
+	}
+	cdr.LineItems = items
+	cdr.Total = roundAmount(cdr.Total)
+	return cdr
+}
+
+// createCDR prices a finished transaction and stores its CDR
+func (handler *CentralSystemHandler) createCDR(transaction *TransactionInfo) *CDR {
+	name, tariff := handler.tariffOf(transaction)
+	cdr := priceTransaction(transaction, name, tariff)
+	handler.CDRs[transaction.Id] = cdr
+	log.WithField("client", transaction.ChargePointID).Infof("CDR for transaction %v: %v %v (tariff %v)", transaction.Id, cdr.Total, cdr.Currency, name)
+	return cdr
+}
+
+// CreateMissingCDRs Http-RPC, prices finished transactions that have no CDR yet, e.g. from before tariffs existed
+func (handler *CentralSystemHandler) CreateMissingCDRs() []int {
+	created := []int{}
+	for id, transaction := range handler.Transactions {
+		if _, ok := handler.CDRs[id]; ok || !transaction.hasTransactionEnded() {
+			continue
+		}
+		handler.createCDR(transaction)
+		created = append(created, id)
+	}
+	sort.Ints(created)
+	return created
+}
+
+// SetTariff Http-RPC, tariff is given as json
+func (handler *CentralSystemHandler) SetTariff(name string, tariffJSON string) (*Tariff, error) {
+	if name == "" {
+		return nil, fmt.Errorf("tariff needs a name")
+	}
+	tariff := &Tariff{}
+	if err := json.Unmarshal([]byte(tariffJSON), tariff); err != nil {
+		return nil, fmt.Errorf("invalid tariff: %v", err)
+	}
+	if err := tariff.validate(); err != nil {
+		return nil, err
+	}
+	handler.Tariffs[name] = tariff
+	log.Printf("tariff %v set", name)
+	return tariff, nil
+}
+
+// DeleteTariff Http-RPC, assigned tariffs can't be deleted
+func (handler *CentralSystemHandler) DeleteTariff(name string) error {
+	if _, ok := handler.Tariffs[name]; !ok {
+		return fmt.Errorf("unknown tariff %v", name)
+	}
+	for groupName, group := range handler.Groups {
+		if group.Tariff == name {
+			return fmt.Errorf("tariff %v is assigned to group %v", name, groupName)
+		}
+	}
+	for _, entry := range handler.GetIdTags("") {
+		if entry.Tariff == name {
+			return fmt.Errorf("tariff %v is assigned to %v", name, entry.IdTag)
+		}
+	}
+	delete(handler.Tariffs, name)
+	return nil
+}
+
+// AssignTariff Http-RPC, target is "group" or "idtag", an empty name removes the assignment
+func (handler *CentralSystemHandler) AssignTariff(target string, id string, name string) error {
+	if _, ok := handler.Tariffs[name]; name != "" && !ok {
+		return fmt.Errorf("unknown tariff %v", name)
+	}
+	switch target {
+	case "group":
+		group, ok := handler.Groups[id]
+		if !ok {
+			return fmt.Errorf("unknown group %v", id)
+		}
+		group.Tariff = name
+	case "idtag":
+		auth, exists := lookupIdentity(id)
+		if !exists {
+			return fmt.Errorf("unknown id tag %v", id)
+		}
+		auth.Tariff = name
+		storeIdentity(id, auth)
+		if err := saveIdentityFile(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown target %v, use group or idtag", target)
+	}
+	log.Printf("tariff of %v %v: %v", target, id, name)
+	return nil
+}
+
+// GetCDRs Http-RPC, CDRs of an id tag (all if empty) with transactions started within [from, to)
+func (handler *CentralSystemHandler) GetCDRs(idTag string, from time.Time, to time.Time) []*CDR {
+	list := []*CDR{}
+	for _, cdr := range handler.CDRs {
+		if idTag != "" && cdr.IdTag != idTag {
+			continue
+		}
+		if cdr.StartTime == nil || (!from.IsZero() && cdr.StartTime.Before(from)) || (!to.IsZero() && !cdr.StartTime.Before(to)) {
+			continue
+		}
+		list = append(list, cdr)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartTime.Before(list[j].StartTime.Time)
+	})
+	return list
+}
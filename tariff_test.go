@@ -0,0 +1,170 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/lorenzodonini/ocpp-go/ocpp1.6/types"
+)
+
+// setBerlin prices in a zone with DST, periods are local wall clock times
+func setBerlin(t *testing.T) *time.Location {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("can't load time zone: %v", err)
+	}
+	local := time.Local
+	time.Local = berlin
+	t.Cleanup(func() { time.Local = local })
+	return berlin
+}
+
+var dayNightTariff = &Tariff{
+	Currency:    "EUR",
+	EnergyPrice: 0.30,
+	Periods: []TariffPeriod{
+		{Start: "07:00", End: "22:00", EnergyPrice: 0.40},
+		{Start: "22:00", End: "07:00", EnergyPrice: 0.25},
+	},
+}
+
+func TestPricesAt(t *testing.T) {
+	berlin := setBerlin(t)
+	tests := []struct {
+		name            string
+		tariff          *Tariff
+		at              time.Time
+		wantEnergyPrice float64
+		wantDescription string
+		wantNext        time.Time
+	}{
+		{name: "base price until midnight", tariff: &Tariff{EnergyPrice: 0.30}, at: time.Date(2026, 6, 1, 10, 0, 0, 0, berlin), wantEnergyPrice: 0.30, wantDescription: "base", wantNext: time.Date(2026, 6, 2, 0, 0, 0, 0, berlin)},
+		{name: "day period", tariff: dayNightTariff, at: time.Date(2026, 6, 1, 10, 0, 0, 0, berlin), wantEnergyPrice: 0.40, wantDescription: "07:00-22:00", wantNext: time.Date(2026, 6, 1, 22, 0, 0, 0, berlin)},
+		{name: "period wrapping midnight, evening", tariff: dayNightTariff, at: time.Date(2026, 6, 1, 23, 30, 0, 0, berlin), wantEnergyPrice: 0.25, wantDescription: "22:00-07:00", wantNext: time.Date(2026, 6, 2, 0, 0, 0, 0, berlin)},
+		{name: "period wrapping midnight, morning", tariff: dayNightTariff, at: time.Date(2026, 6, 2, 3, 0, 0, 0, berlin), wantEnergyPrice: 0.25, wantDescription: "22:00-07:00", wantNext: time.Date(2026, 6, 2, 7, 0, 0, 0, berlin)},
+		{name: "period boundary", tariff: dayNightTariff, at: time.Date(2026, 6, 1, 22, 0, 0, 0, berlin), wantEnergyPrice: 0.25, wantDescription: "22:00-07:00", wantNext: time.Date(2026, 6, 2, 0, 0, 0, 0, berlin)},
+		{name: "DST fall back, late evening", tariff: dayNightTariff, at: time.Date(2026, 10, 25, 23, 30, 0, 0, berlin), wantEnergyPrice: 0.25, wantDescription: "22:00-07:00", wantNext: time.Date(2026, 10, 26, 0, 0, 0, 0, berlin)},
+		{name: "DST fall back, day period ends at wall clock time", tariff: dayNightTariff, at: time.Date(2026, 10, 25, 10, 0, 0, 0, berlin), wantEnergyPrice: 0.40, wantDescription: "07:00-22:00", wantNext: time.Date(2026, 10, 25, 22, 0, 0, 0, berlin)},
+		{name: "DST spring forward", tariff: dayNightTariff, at: time.Date(2026, 3, 29, 1, 30, 0, 0, berlin), wantEnergyPrice: 0.25, wantDescription: "22:00-07:00", wantNext: time.Date(2026, 3, 29, 7, 0, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			energyPrice, _, description, next := tt.tariff.pricesAt(tt.at)
+			if energyPrice != tt.wantEnergyPrice || description != tt.wantDescription || !next.Equal(tt.wantNext) {
+				t.Fatalf("got %v %v %v, want %v %v %v", energyPrice, description, next, tt.wantEnergyPrice, tt.wantDescription, tt.wantNext)
+			}
+			if !next.After(tt.at) {
+				t.Fatalf("next change %v isn't after %v", next, tt.at)
+			}
+		})
+	}
+}
+
+func TestPriceTransaction(t *testing.T) {
+	berlin := setBerlin(t)
+	at := func(month time.Month, day int, hour int, minute int) *types.DateTime {
+		return types.NewDateTime(time.Date(2026, month, day, hour, minute, 0, 0, berlin))
+	}
+	tests := []struct {
+		name        string
+		tariff      *Tariff
+		transaction TransactionInfo
+		wantEnergy  int
+		wantTotal   float64
+		wantItems   map[string]float64
+	}{
+		{
+			name:        "energy and time",
+			tariff:      &Tariff{Currency: "EUR", EnergyPrice: 0.30, TimePrice: 0.05},
+			transaction: TransactionInfo{StartTime: at(6, 1, 10, 0), EndTime: at(6, 1, 11, 0), StartMeter: 1000, EndMeter: 11000},
+			wantEnergy:  10000,
+			wantTotal:   6,
+			wantItems:   map[string]float64{"energy base": 10, "time base": 60},
+		},
+		{
+			name:        "split over periods",
+			tariff:      dayNightTariff,
+			transaction: TransactionInfo{StartTime: at(6, 1, 21, 0), EndTime: at(6, 1, 23, 0), EndMeter: 10000},
+			wantEnergy:  10000,
+			wantTotal:   3.25,
+			wantItems:   map[string]float64{"energy 07:00-22:00": 5, "energy 22:00-07:00": 5},
+		},
+		{
+			name:        "period wrapping midnight",
+			tariff:      dayNightTariff,
+			transaction: TransactionInfo{StartTime: at(6, 1, 23, 0), EndTime: at(6, 2, 1, 0), EndMeter: 8000},
+			wantEnergy:  8000,
+			wantTotal:   2,
+			wantItems:   map[string]float64{"energy 22:00-07:00": 8},
+		},
+		{
+			name:        "idle after grace",
+			tariff:      &Tariff{Currency: "EUR", EnergyPrice: 0.30, TimePrice: 0.05, IdleFee: 0.10, IdleGraceMinutes: 15},
+			transaction: TransactionInfo{StartTime: at(6, 1, 10, 0), IdleSince: at(6, 1, 11, 0), EndTime: at(6, 1, 11, 30), EndMeter: 10000},
+			wantEnergy:  10000,
+			wantTotal:   7.5,
+			wantItems:   map[string]float64{"energy base": 10, "time base": 60, "idle after 15 min grace": 15},
+		},
+		{
+			name:        "idle within grace",
+			tariff:      &Tariff{Currency: "EUR", EnergyPrice: 0.30, IdleFee: 0.10, IdleGraceMinutes: 15},
+			transaction: TransactionInfo{StartTime: at(6, 1, 10, 0), IdleSince: at(6, 1, 11, 0), EndTime: at(6, 1, 11, 10), EndMeter: 10000},
+			wantEnergy:  10000,
+			wantTotal:   3,
+			wantItems:   map[string]float64{"energy base": 10},
+		},
+		{
+			name:        "DST fall back",
+			tariff:      dayNightTariff,
+			transaction: TransactionInfo{StartTime: at(10, 25, 23, 0), EndTime: at(10, 26, 1, 0), EndMeter: 4000},
+			wantEnergy:  4000,
+			wantTotal:   1,
+			wantItems:   map[string]float64{"energy 22:00-07:00": 4},
+		},
+		{
+			name:        "DST fall back over the repeated hour",
+			tariff:      &Tariff{Currency: "EUR", TimePrice: 0.01, Periods: []TariffPeriod{{Start: "07:00", End: "22:00", TimePrice: 0.02}}},
+			transaction: TransactionInfo{StartTime: at(10, 25, 0, 0), EndTime: at(10, 25, 8, 0)},
+			wantTotal:   6,
+			// 8 wall clock hours are 9 real ones
+			wantItems: map[string]float64{"time base": 480, "time 07:00-22:00": 60},
+		},
+		{
+			name:        "DST spring forward",
+			tariff:      &Tariff{Currency: "EUR", TimePrice: 0.01, Periods: []TariffPeriod{{Start: "07:00", End: "22:00", TimePrice: 0.02}}},
+			transaction: TransactionInfo{StartTime: at(3, 29, 0, 0), EndTime: at(3, 29, 8, 0)},
+			wantTotal:   4.8,
+			wantItems:   map[string]float64{"time base": 360, "time 07:00-22:00": 60},
+		},
+		{
+			name:        "meter stop below meter start",
+			tariff:      &Tariff{Currency: "EUR", EnergyPrice: 0.30},
+			transaction: TransactionInfo{StartTime: at(6, 1, 10, 0), EndTime: at(6, 1, 11, 0), StartMeter: 5000, EndMeter: 4000},
+			wantItems:   map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cdr := priceTransaction(&tt.transaction, "test", tt.tariff)
+			if cdr.EnergyWh != tt.wantEnergy || math.Abs(cdr.Total-tt.wantTotal) > 1e-9 {
+				t.Fatalf("got %v Wh for %v, want %v Wh for %v", cdr.EnergyWh, cdr.Total, tt.wantEnergy, tt.wantTotal)
+			}
+			items := map[string]float64{}
+			for _, item := range cdr.LineItems {
+				if item.Type != "session" {
+					items[item.Type+" "+item.Description] = item.Quantity
+				}
+			}
+			if len(items) != len(tt.wantItems) {
+				t.Fatalf("got line items %v, want %v", items, tt.wantItems)
+			}
+			for key, quantity := range tt.wantItems {
+				if math.Abs(items[key]-quantity) > 1e-9 {
+					t.Fatalf("got line items %v, want %v", items, tt.wantItems)
+				}
+			}
+		})
+	}
+}